@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"github.com/urvin/gokaru/internal/config"
 	"github.com/urvin/gokaru/internal/di"
+	"github.com/urvin/gokaru/internal/queue"
 	"github.com/urvin/gokaru/internal/server"
 	"github.com/urvin/gokaru/internal/vips"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const SHUTDOWN_TIMEOUT_DEFAULT = 30 * time.Second
+
 func main() {
 	err := config.Init()
 	if err != nil {
@@ -27,16 +34,95 @@ func main() {
 		)
 		panic(err)
 	}
-	defer vips.Shutdown()
 
-	http := server.NewServer(di.Logger())
-	err = http.ListenAndServe()
+	// the queue opens and replays the post-processing journal, it fails boot rather than the first thumbnail request
+	_, err = di.SafeGet("queue")
 	if err != nil {
+		di.Logger().Error(
+			"queue startup fail",
+			"context", "main",
+			"error", err.Error(),
+		)
+		vips.Shutdown()
+		panic(err)
+	}
+
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	http := server.NewServer(di.Logger())
+	crashed := make(chan error, 1)
+	go func() {
+		crashed <- http.ListenAndServe()
+	}()
+
+	select {
+	case err = <-crashed:
 		di.Logger().Error(
 			"http server crashed",
 			"context", "main",
 			"error", err.Error(),
 		)
+		vips.Shutdown()
 		panic(err)
+	case <-signals.Done():
+		stop()
+	}
+
+	shutdown(http)
+}
+
+// shutdown finishes requests in progress, drains the thumbnail queue and releases services
+func shutdown(http server.Server) {
+	logger := di.Logger()
+	logger.Info(
+		"Shutting down",
+		"context", "main",
+	)
+
+	timeout := config.Get().ShutdownTimeout
+	if timeout <= 0 {
+		timeout = SHUTDOWN_TIMEOUT_DEFAULT
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := http.Shutdown(ctx)
+	if err != nil {
+		logger.Error(
+			"http server shutdown fail",
+			"context", "main",
+			"error", err.Error(),
+		)
+	}
+
+	drained := true
+	err = di.Get("queue").(*queue.Queue).Close(ctx)
+	if err != nil {
+		drained = false
+		logger.Error(
+			"queue drain fail",
+			"context", "main",
+			"error", err.Error(),
+		)
 	}
+
+	err = di.Close()
+	if err != nil {
+		logger.Error(
+			"services close fail",
+			"context", "main",
+			"error", err.Error(),
+		)
+	}
+
+	// thumbnails still in progress would crash on vips shutdown
+	if drained {
+		vips.Shutdown()
+	}
+
+	logger.Info(
+		"Stopped",
+		"context", "main",
+	)
 }
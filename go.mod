@@ -5,10 +5,10 @@ go 1.24.1
 require (
 	github.com/fasthttp/router v1.5.4
 	github.com/kelseyhightower/envconfig v1.4.0
-	github.com/n-marshall/go-cp v0.0.0-20180115193924-61436d3b7cfa
 	github.com/sarulabs/di v2.0.0+incompatible
 	github.com/spaolacci/murmur3 v1.1.0
 	github.com/valyala/fasthttp v1.59.0
+	go.etcd.io/bbolt v1.3.11
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -18,4 +18,5 @@ require (
 	github.com/savsgio/gotils v0.0.0-20240704082632-aef3928b8a38 // indirect
 	github.com/stretchr/testify v1.10.0 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
 )
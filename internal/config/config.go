@@ -1,25 +1,253 @@
 package config
 
+import (
+	"cmp"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"time"
+)
+
 type Config struct {
-	Port                 int    `yaml:"port" envconfig:"GOKARU_PORT" default:"80"`
-	MaxUploadSize        int    `yaml:"max_upload_size" envconfig:"GOKARU_MAX_UPLOAD_SIZE" default:"100"`
-	SignatureSalt        string `yaml:"signature_salt" envconfig:"GOKARU_SIGNATURE_SALT" default:"secret"`
-	SignatureAlgorithm   string `yaml:"signature_algorithm" envconfig:"GOKARU_SIGNATURE_ALGORITHM" default:"murmur"`
-	StoragePath          string `yaml:"storage_path" envconfig:"GOKARU_STORAGE_PATH" default:"./storage/"`
-	EnforceWebp          bool   `yaml:"enforce_webp" envconfig:"GOKARU_ENFORCE_WEBP" default:"true"`
-	ThumbnailerProcs     uint   `yaml:"thumbnailer_procs" envconfig:"GOKARU_THUMBNAILER_PROCS" default:"0"`
-	ThumbnailerPostProcs uint   `yaml:"thumbnailer_post_procs" envconfig:"GOKARU_THUMBNAILER_POST_PROCS" default:"0"`
-	Padding              uint   `yaml:"padding" envconfig:"GOKARU_PADDING" default:"10"`
-	QualityDefault       uint   `yaml:"quality_default" envconfig:"GOKARU_QUALITY_DEFAULT" default:"80"`
-	Quality              []struct {
-		Format     string `yaml:"format"`
-		Quality    uint   `yaml:"quality"`
-		Iterations uint   `yaml:"iterations"  default:"100"`
-		Conditions []struct {
-			From       uint `yaml:"from"`
-			To         uint `yaml:"to"`
-			Quality    uint `yaml:"quality"`
-			Iterations uint `yaml:"iterations"  default:"100"`
+	Port                 int           `yaml:"port" envconfig:"GOKARU_PORT" default:"80"`
+	MaxUploadSize        int           `yaml:"max_upload_size" envconfig:"GOKARU_MAX_UPLOAD_SIZE" default:"100"`
+	SignatureSalt        string        `yaml:"signature_salt" envconfig:"GOKARU_SIGNATURE_SALT" default:"secret"`
+	SignatureAlgorithm   string        `yaml:"signature_algorithm" envconfig:"GOKARU_SIGNATURE_ALGORITHM" default:"murmur"`
+	StoragePath          string        `yaml:"storage_path" envconfig:"GOKARU_STORAGE_PATH" default:"./storage/"`
+	EnforceWebp          bool          `yaml:"enforce_webp" envconfig:"GOKARU_ENFORCE_WEBP" default:"true"`
+	EnforceJxl           bool          `yaml:"enforce_jxl" envconfig:"GOKARU_ENFORCE_JXL"`
+	PreferredFormats     []string      `yaml:"preferred_formats" envconfig:"GOKARU_PREFERRED_FORMATS"`
+	ThumbnailerProcs     uint          `yaml:"thumbnailer_procs" envconfig:"GOKARU_THUMBNAILER_PROCS" default:"0"`
+	ThumbnailerPostProcs uint          `yaml:"thumbnailer_post_procs" envconfig:"GOKARU_THUMBNAILER_POST_PROCS" default:"0"`
+	ShutdownTimeout      time.Duration `yaml:"shutdown_timeout" envconfig:"GOKARU_SHUTDOWN_TIMEOUT"`
+	QueueSize            uint          `yaml:"queue_size" envconfig:"GOKARU_QUEUE_SIZE"`
+	QueueWait            time.Duration `yaml:"queue_wait" envconfig:"GOKARU_QUEUE_WAIT"`
+	ThumbnailTimeout     time.Duration `yaml:"thumbnail_timeout" envconfig:"GOKARU_THUMBNAIL_TIMEOUT"`
+	Padding              uint          `yaml:"padding" envconfig:"GOKARU_PADDING" default:"10"`
+	TransparentFuzz      uint          `yaml:"transparent_fuzz" envconfig:"GOKARU_TRANSPARENT_FUZZ"`
+	QualityDefault       uint          `yaml:"quality_default" envconfig:"GOKARU_QUALITY_DEFAULT" default:"80"`
+	NameGenerator        string        `yaml:"name_generator" envconfig:"GOKARU_NAME_GENERATOR"`
+	MaxWidth             int           `yaml:"max_width" envconfig:"GOKARU_MAX_WIDTH"`
+	MaxHeight            int           `yaml:"max_height" envconfig:"GOKARU_MAX_HEIGHT"`
+	MaxArea              int           `yaml:"max_area" envconfig:"GOKARU_MAX_AREA"`
+	Quality              []Quality     `yaml:"quality"`
+	ThumbnailerReserved  Reserved      `yaml:"thumbnailer_reserved"`
+	Presets              []Preset      `yaml:"presets"`
+	Categories           []Category    `yaml:"categories"`
+	Fetch                Fetch         `yaml:"fetch"`
+	Postprocessing       []Pipeline    `yaml:"postprocessing"`
+}
+
+// Quality settings of a format, conditions apply to thumbnails of half perimeter from inclusive to exclusive
+type Quality struct {
+	Format     string             `yaml:"format"`
+	Quality    uint               `yaml:"quality"`
+	Iterations uint               `yaml:"iterations"  default:"100"`
+	Effort     uint               `yaml:"effort"`
+	Encoder    Encoder            `yaml:"encoder"`
+	Target     Target             `yaml:"target"`
+	Conditions []QualityCondition `yaml:"conditions"`
+}
+
+// Target similarity of thumbnails to their unencoded images, quality is searched per thumbnail within bounds, 0 ssim
+// to use fixed quality
+type Target struct {
+	Ssim        float64 `yaml:"ssim"`
+	MinQuality  uint    `yaml:"min_quality"`
+	MaxQuality  uint    `yaml:"max_quality"`
+	MaxAttempts uint    `yaml:"max_attempts"`
+}
+
+type QualityCondition struct {
+	From       uint    `yaml:"from"`
+	To         uint    `yaml:"to"`
+	Quality    uint    `yaml:"quality"`
+	Iterations uint    `yaml:"iterations"  default:"100"`
+	Effort     uint    `yaml:"effort"`
+	Encoder    Encoder `yaml:"encoder"`
+}
+
+// Encoder options, options not set keep encoder defaults, ones of other formats are ignored
+type Encoder struct {
+	// jpg, interlace applies to png too
+	Interlace          *bool  `yaml:"interlace"`
+	OptimizeCoding     *bool  `yaml:"optimize_coding"`
+	Subsample          string `yaml:"subsample"`
+	TrellisQuant       *bool  `yaml:"trellis_quant"`
+	OvershootDeringing *bool  `yaml:"overshoot_deringing"`
+	OptimizeScans      *bool  `yaml:"optimize_scans"`
+	QuantTable         *int   `yaml:"quant_table"`
+	// png
+	Compression *int `yaml:"compression"`
+	Colors      *int `yaml:"colors"`
+	// webp, avif and jxl
+	Lossless        *bool `yaml:"lossless"`
+	SmartSubsample  *bool `yaml:"smart_subsample"`
+	ReductionEffort *int  `yaml:"reduction_effort"`
+	Speed           *int  `yaml:"speed"`
+}
+
+// Merge returns options overridden by ones set in other
+func (e Encoder) Merge(other Encoder) Encoder {
+	e.Interlace = cmp.Or(other.Interlace, e.Interlace)
+	e.OptimizeCoding = cmp.Or(other.OptimizeCoding, e.OptimizeCoding)
+	e.Subsample = cmp.Or(other.Subsample, e.Subsample)
+	e.TrellisQuant = cmp.Or(other.TrellisQuant, e.TrellisQuant)
+	e.OvershootDeringing = cmp.Or(other.OvershootDeringing, e.OvershootDeringing)
+	e.OptimizeScans = cmp.Or(other.OptimizeScans, e.OptimizeScans)
+	e.QuantTable = cmp.Or(other.QuantTable, e.QuantTable)
+	e.Compression = cmp.Or(other.Compression, e.Compression)
+	e.Colors = cmp.Or(other.Colors, e.Colors)
+	e.Lossless = cmp.Or(other.Lossless, e.Lossless)
+	e.SmartSubsample = cmp.Or(other.SmartSubsample, e.SmartSubsample)
+	e.ReductionEffort = cmp.Or(other.ReductionEffort, e.ReductionEffort)
+	e.Speed = cmp.Or(other.Speed, e.Speed)
+	return e
+}
+
+// Reserved numbers of thumbnailing processes kept for priority classes
+type Reserved struct {
+	Interactive uint `yaml:"interactive"`
+	Warmup      uint `yaml:"warmup"`
+	Batch       uint `yaml:"batch"`
+}
+
+type Preset struct {
+	Name    string  `yaml:"name"`
+	Width   int     `yaml:"width"`
+	Height  int     `yaml:"height"`
+	Cast    int     `yaml:"cast"`
+	Format  string  `yaml:"format"`
+	Quality uint    `yaml:"quality"`
+	Filters Filters `yaml:"filters"`
+}
+
+// Key describes the preset settings, it changes along with any of them
+func (preset Preset) Key() string {
+	hash := md5.Sum([]byte(fmt.Sprintf("%+v", preset)))
+	return preset.Name + "-" + hex.EncodeToString(hash[:4])
+}
+
+type Filters struct {
+	Blur    float32 `yaml:"blur"`
+	Sharpen float32 `yaml:"sharpen"`
+}
+
+type Category struct {
+	Name        string    `yaml:"name"`
+	PresetsOnly bool      `yaml:"presets_only"`
+	Warmup      []string  `yaml:"warmup"`
+	Sizes       []Size    `yaml:"sizes"`
+	Casts       []int     `yaml:"casts"`
+	Trim        Trim      `yaml:"trim"`
+	Quality     []Quality `yaml:"quality"`
+	// PreferredFormats replaces the global list, empty to disable negotiation
+	PreferredFormats []string `yaml:"preferred_formats"`
+	// Profile is srgb, keep or p3, empty for srgb
+	Profile string `yaml:"profile"`
+	// Metadata kept in thumbnails: copyright, artist and xmp_rights
+	Metadata []string `yaml:"metadata"`
+	// StripPrivateMetadata removes location, serial numbers, owner and XMP of uploaded image origins
+	StripPrivateMetadata bool       `yaml:"strip_private_metadata"`
+	Versioning           Versioning `yaml:"versioning"`
+}
+
+// Trim settings, unset values keep global ones
+type Trim struct {
+	Threshold *float64 `yaml:"threshold"`
+	Color     string   `yaml:"color"`
+	Padding   *uint    `yaml:"padding"`
+}
+
+type Size struct {
+	Width  int `yaml:"width"`
+	Height int `yaml:"height"`
+}
+
+type Versioning struct {
+	Enabled  bool          `yaml:"enabled"`
+	MaxCount uint          `yaml:"max_count"`
+	MaxAge   time.Duration `yaml:"max_age"`
+}
+
+type Fetch struct {
+	Timeout      time.Duration `yaml:"timeout"`
+	MaxSize      int           `yaml:"max_size"`
+	MaxRedirects int           `yaml:"max_redirects"`
+	AllowHosts   []string      `yaml:"allow_hosts"`
+	DenyHosts    []string      `yaml:"deny_hosts"`
+	AllowPrivate bool          `yaml:"allow_private"`
+}
+
+// Pipeline of tools run over stored thumbnails of a format
+type Pipeline struct {
+	Format string `yaml:"format"`
+	Steps  []Step `yaml:"steps"`
+}
+
+type Step struct {
+	Name          string        `yaml:"name"`
+	Command       string        `yaml:"command"`
+	Args          []string      `yaml:"args"`
+	Timeout       time.Duration `yaml:"timeout"`
+	KeepIfSmaller bool          `yaml:"keep_if_smaller"`
+}
+
+// Preset returns the named thumbnail preset
+func (c Config) Preset(name string) (preset Preset, ok bool) {
+	for _, preset = range c.Presets {
+		if preset.Name == name {
+			ok = true
+			return
+		}
+	}
+	preset = Preset{}
+	return
+}
+
+// QualityOf returns quality settings of a format, named by any of formats, in a category, a category entry of
+// the format replaces the global one
+func (c Config) QualityOf(category string, formats ...string) (Quality, bool) {
+	for _, qualities := range [][]Quality{c.Category(category).Quality, c.Quality} {
+		for _, quality := range qualities {
+			if slices.Contains(formats, quality.Format) {
+				return quality, true
+			}
+		}
+	}
+	return Quality{}, false
+}
+
+// FORMAT_ORIGINAL stands for the requested format in preferred formats
+const FORMAT_ORIGINAL = "original"
+
+// PreferredFormatsOf returns formats negotiated by Accept header in a category in order of preference, without the
+// list configured enforce_jxl and enforce_webp are used
+func (c Config) PreferredFormatsOf(category string) []string {
+	if formats := c.Category(category).PreferredFormats; formats != nil {
+		return formats
+	}
+	if c.PreferredFormats != nil {
+		return c.PreferredFormats
+	}
+
+	var formats []string
+	if c.EnforceJxl {
+		formats = append(formats, "jxl")
+	}
+	if c.EnforceWebp {
+		formats = append(formats, "webp")
+	}
+	return append(formats, FORMAT_ORIGINAL)
+}
+
+// Category returns settings of the named category, zero value if the category is not configured
+func (c Config) Category(name string) Category {
+	for _, category := range c.Categories {
+		if category.Name == name {
+			return category
 		}
-	} `yaml:"quality"`
+	}
+	return Category{Name: name}
 }
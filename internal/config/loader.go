@@ -17,7 +17,11 @@ func Init() (err error) {
 	}
 
 	err = readEnv(config)
+	if err != nil {
+		return
+	}
 
+	err = config.validate()
 	return
 }
 
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"errors"
+	"github.com/urvin/gokaru/internal/contracts"
+	"slices"
+	"strconv"
+)
+
+// FORMATS are formats thumbnails could be made in
+var FORMATS = []string{"jpg", "jpeg", "png", "webp", "avif", "jxl", "gif", "tiff"}
+
+var SUBSAMPLE_MODES = []string{"auto", "on", "off"}
+
+// validate checks settings which would otherwise fail every thumbnail
+func (c *Config) validate() error {
+	if err := validateQualities(c.Quality); err != nil {
+		return errors.New("quality: " + err.Error())
+	}
+	if err := validateFormats(c.PreferredFormats); err != nil {
+		return errors.New("preferred_formats: " + err.Error())
+	}
+	for _, category := range c.Categories {
+		if err := validateQualities(category.Quality); err != nil {
+			return errors.New("category " + category.Name + " quality: " + err.Error())
+		}
+		if err := validateFormats(category.PreferredFormats); err != nil {
+			return errors.New("category " + category.Name + " preferred_formats: " + err.Error())
+		}
+		if category.Profile != "" && !slices.Contains(contracts.PROFILES, category.Profile) {
+			return errors.New("category " + category.Name + " profile: unknown profile " + category.Profile)
+		}
+		for _, name := range category.Metadata {
+			if !slices.Contains(contracts.METADATA, name) {
+				return errors.New("category " + category.Name + " metadata: unknown metadata " + name)
+			}
+		}
+	}
+	return nil
+}
+
+func validateFormats(formats []string) error {
+	for _, format := range formats {
+		if format != FORMAT_ORIGINAL && !slices.Contains(FORMATS, format) {
+			return errors.New("unknown format " + format)
+		}
+	}
+	return nil
+}
+
+func validateQualities(qualities []Quality) error {
+	for _, quality := range qualities {
+		if !slices.Contains(FORMATS, quality.Format) {
+			return errors.New("unknown format " + quality.Format)
+		}
+		if err := validateQuality(quality.Quality, quality.Effort, quality.Encoder); err != nil {
+			return errors.New(quality.Format + ": " + err.Error())
+		}
+		if err := validateTarget(quality.Target); err != nil {
+			return errors.New(quality.Format + " target: " + err.Error())
+		}
+		for _, condition := range quality.Conditions {
+			if condition.From >= condition.To {
+				return errors.New(quality.Format + ": condition from " + strconv.Itoa(int(condition.From)) + " is not less than to " + strconv.Itoa(int(condition.To)))
+			}
+			if err := validateQuality(condition.Quality, condition.Effort, condition.Encoder); err != nil {
+				return errors.New(quality.Format + " condition: " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func validateQuality(quality, effort uint, encoder Encoder) error {
+	if quality > 100 {
+		return errors.New("quality should be from 0 to 100")
+	}
+	if effort > 9 {
+		return errors.New("effort should be from 0 to 9")
+	}
+	if encoder.Subsample != "" && !slices.Contains(SUBSAMPLE_MODES, encoder.Subsample) {
+		return errors.New("subsample should be auto, on or off")
+	}
+	if err := validateRange("quant_table", encoder.QuantTable, 0, 8); err != nil {
+		return err
+	}
+	if err := validateRange("compression", encoder.Compression, 0, 9); err != nil {
+		return err
+	}
+	if err := validateRange("colors", encoder.Colors, 2, 256); err != nil {
+		return err
+	}
+	if err := validateRange("reduction_effort", encoder.ReductionEffort, 0, 6); err != nil {
+		return err
+	}
+	return validateRange("speed", encoder.Speed, 0, 9)
+}
+
+func validateTarget(target Target) error {
+	if target.Ssim < 0 || target.Ssim >= 1 {
+		return errors.New("ssim should be from 0 to 1")
+	}
+	if target.MaxQuality > 100 {
+		return errors.New("max_quality should be from 0 to 100")
+	}
+	if target.MaxQuality > 0 && target.MinQuality > target.MaxQuality {
+		return errors.New("min_quality is greater than max_quality")
+	}
+	return nil
+}
+
+func validateRange(name string, value *int, from, to int) error {
+	if value != nil && (*value < from || *value > to) {
+		return errors.New(name + " should be from " + strconv.Itoa(from) + " to " + strconv.Itoa(to))
+	}
+	return nil
+}
@@ -2,3 +2,26 @@ package contracts
 
 const STORAGE_TYPE_IMAGE = "image"
 const STORAGE_TYPE_FILE = "file"
+
+const NAME_GENERATOR_UUID = "uuid"
+const NAME_GENERATOR_HASH = "hash"
+
+const WARMUP_STATUS_QUEUED = "queued"
+const WARMUP_STATUS_DROPPED = "dropped"
+
+const TRIM_COLOR_AUTO = "auto"
+
+// colour profiles of thumbnails: converted to sRGB, kept as embedded or converted to Display P3
+const PROFILE_SRGB = "srgb"
+const PROFILE_KEEP = "keep"
+const PROFILE_P3 = "p3"
+
+var PROFILES = []string{PROFILE_SRGB, PROFILE_KEEP, PROFILE_P3}
+
+// metadata which could be kept in thumbnails, METADATA_NONE overrides a category list with nothing
+const METADATA_COPYRIGHT = "copyright"
+const METADATA_ARTIST = "artist"
+const METADATA_XMP_RIGHTS = "xmp_rights"
+const METADATA_NONE = "none"
+
+var METADATA = []string{METADATA_COPYRIGHT, METADATA_ARTIST, METADATA_XMP_RIGHTS}
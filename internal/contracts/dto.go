@@ -2,6 +2,7 @@ package contracts
 
 import (
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -11,11 +12,30 @@ type OriginDto struct {
 	Name     string
 }
 
+func (origin *OriginDto) Hash() string {
+	return origin.Type + "/" +
+		origin.Category + "/" +
+		origin.Name
+}
+
+// OriginInfoDto keeps facts of an image origin, which describe it and pick formats of its thumbnails
+type OriginInfoDto struct {
+	Width  int
+	Height int
+	// Format is the auto thumbnail format of clients without explicit Accept formats
+	Format   string
+	Animated bool
+	Alpha    bool
+	Palette  bool
+}
+
 type FileDto struct {
 	Size             int64
 	ModificationTime time.Time
 	ContentType      string
 	Contents         []byte
+	// Quality chosen by a target similarity search for a thumbnail, 0 otherwise
+	Quality uint
 }
 
 type MiniatureDto struct {
@@ -26,13 +46,90 @@ type MiniatureDto struct {
 	Width     int
 	Height    int
 	Cast      int
+	Preset    string
+	Trim      TrimDto
+	Preserve  PreserveDto
+}
+
+// TrimDto overrides trim settings of a thumbnail, nil and empty values keep category or global settings
+type TrimDto struct {
+	Threshold *float64
+	// hex rrggbb, TRIM_COLOR_AUTO for the corner colour
+	Color   string
+	Padding *int
+}
+
+func (trim TrimDto) IsZero() bool {
+	return trim.Threshold == nil && trim.Color == "" && trim.Padding == nil
+}
+
+// Key describes overridden trim settings, empty if there are none
+func (trim TrimDto) Key() string {
+	if trim.IsZero() {
+		return ""
+	}
+	key := "trim-"
+	if trim.Threshold != nil {
+		key += strconv.FormatFloat(*trim.Threshold, 'f', -1, 64)
+	}
+	key += "-" + trim.Color + "-"
+	if trim.Padding != nil {
+		key += strconv.Itoa(*trim.Padding)
+	}
+	return key
+}
+
+// PreserveDto overrides colour profile and metadata kept in a thumbnail, zero values keep category settings
+type PreserveDto struct {
+	Profile string
+	// sorted comma separated metadata names, METADATA_NONE to keep nothing
+	Metadata string
+}
+
+func (preserve PreserveDto) IsZero() bool {
+	return preserve == PreserveDto{}
+}
+
+// Key describes overridden preserve settings, empty if there are none
+func (preserve PreserveDto) Key() string {
+	if preserve.IsZero() {
+		return ""
+	}
+	return "preserve-" + preserve.Profile + "-" + strings.ReplaceAll(preserve.Metadata, ",", "+")
 }
 
 func (miniature *MiniatureDto) Hash() string {
-	return miniature.Type + "/" +
+	// preset thumbnails are addressed by name, so that preset settings could be tuned without changing urls
+	if miniature.Preset != "" {
+		return miniature.Type + "/" +
+			miniature.Category + "/" +
+			miniature.Name + "." + miniature.Extension + "/" +
+			miniature.Preset
+	}
+	hash := miniature.Type + "/" +
 		miniature.Category + "/" +
 		miniature.Name + "." + miniature.Extension + "/" +
 		strconv.Itoa(miniature.Width) + "/" +
 		strconv.Itoa(miniature.Height) + "/" +
 		strconv.Itoa(miniature.Cast)
+	// thumbnails without overrides keep their hashes and signatures
+	if !miniature.Trim.IsZero() {
+		hash += "/" + miniature.Trim.Key()
+	}
+	if !miniature.Preserve.IsZero() {
+		hash += "/" + miniature.Preserve.Key()
+	}
+	return hash
+}
+
+type VersionDto struct {
+	Id               string
+	Size             int64
+	ModificationTime time.Time
+}
+
+type EntryDto struct {
+	Name             string
+	Size             int64
+	ModificationTime time.Time
 }
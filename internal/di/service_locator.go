@@ -3,6 +3,7 @@ package di
 import (
 	"github.com/sarulabs/di"
 	"github.com/urvin/gokaru/internal/config"
+	"github.com/urvin/gokaru/internal/fetcher"
 	"github.com/urvin/gokaru/internal/helper"
 	"github.com/urvin/gokaru/internal/queue"
 	"github.com/urvin/gokaru/internal/security"
@@ -10,8 +11,11 @@ import (
 	"github.com/urvin/gokaru/internal/thumbnailer"
 	"log/slog"
 	"os"
+	"path/filepath"
 )
 
+const QUEUE_SIZE_PER_PROC = 64
+
 var container di.Container
 
 func Init() (err error) {
@@ -35,8 +39,10 @@ func Init() (err error) {
 	err = builder.Add(di.Def{
 		Name: "storage",
 		Build: func(ctn di.Container) (interface{}, error) {
-			s := storage.NewFileStorage(config.Get().StoragePath)
-			return s, nil
+			return storage.NewFileStorage(config.Get().StoragePath)
+		},
+		Close: func(obj interface{}) error {
+			return obj.(storage.Storage).Close()
 		},
 	})
 	if err != nil {
@@ -60,12 +66,35 @@ func Init() (err error) {
 		return
 	}
 
+	err = builder.Add(di.Def{
+		Name: "fetcher",
+		Build: func(ctn di.Container) (interface{}, error) {
+			maxSize := int64(config.Get().MaxUploadSize) * 1024 * 1024
+			return fetcher.NewHttpFetcher(config.Get().Fetch, maxSize), nil
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	err = builder.Add(di.Def{
+		Name: "thumbnailer",
+		Build: func(ctn di.Container) (interface{}, error) {
+			logger := ctn.Get("logger").(*slog.Logger)
+			t := thumbnailer.NewThumbnailer(logger)
+			return t, nil
+		},
+	})
+	if err != nil {
+		return
+	}
+
 	err = builder.Add(di.Def{
 		Name: "queue",
 		Build: func(ctn di.Container) (interface{}, error) {
 			logger := ctn.Get("logger").(*slog.Logger)
 			strg := ctn.Get("storage").(storage.Storage)
-			thmbnlr := thumbnailer.NewThumbnailer(logger)
+			thmbnlr := ctn.Get("thumbnailer").(thumbnailer.Thumbnailer)
 
 			procs := config.Get().ThumbnailerProcs
 			postProcs := config.Get().ThumbnailerPostProcs
@@ -82,7 +111,32 @@ func Init() (err error) {
 				}
 			}
 
-			s := queue.NewQueue(logger, strg, thmbnlr, procs, postProcs)
+			size := config.Get().QueueSize
+			if size == 0 {
+				size = procs * QUEUE_SIZE_PER_PROC
+			}
+
+			reserved := config.Get().ThumbnailerReserved
+			reservedProcs := map[queue.Priority]uint{
+				queue.PRIORITY_INTERACTIVE: reserved.Interactive,
+				queue.PRIORITY_WARMUP:      reserved.Warmup,
+				queue.PRIORITY_BATCH:       reserved.Batch,
+			}
+			if err := queue.CheckReserved(procs, reservedProcs); err != nil {
+				return nil, err
+			}
+
+			journal, err := queue.NewJournal(filepath.Join(config.Get().StoragePath, queue.JOURNAL_FILENAME))
+			if err != nil {
+				return nil, err
+			}
+
+			s := queue.NewQueue(logger, strg, thmbnlr, journal, procs, postProcs, queue.Limits{
+				Size:       size,
+				Wait:       config.Get().QueueWait,
+				JobTimeout: config.Get().ThumbnailTimeout,
+				Reserved:   reservedProcs,
+			})
 			return s, nil
 		},
 	})
@@ -99,7 +153,17 @@ func Get(name string) interface{} {
 	return container.Get(name)
 }
 
+// SafeGet builds a service returning its build error instead of panicking
+func SafeGet(name string) (interface{}, error) {
+	return container.SafeGet(name)
+}
+
 func Logger() *slog.Logger {
 	logger := container.Get("logger").(*slog.Logger)
 	return logger
 }
+
+// Close releases services built so far
+func Close() error {
+	return container.Delete()
+}
@@ -0,0 +1,14 @@
+package fetcher
+
+import "errors"
+
+var (
+	ErrInvalidUrl       = errors.New("invalid url")
+	ErrForbiddenHost    = errors.New("host is not allowed")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrTooLarge         = errors.New("remote file is too large")
+)
+
+type Fetcher interface {
+	Fetch(url string) (data []byte, err error)
+}
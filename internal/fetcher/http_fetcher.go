@@ -0,0 +1,168 @@
+package fetcher
+
+import (
+	"errors"
+	"github.com/urvin/gokaru/internal/config"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const TIMEOUT_DEFAULT = 30 * time.Second
+
+type httpFetcher struct {
+	client       *http.Client
+	maxSize      int64
+	maxRedirects int
+	allowHosts   []string
+	denyHosts    []string
+	allowPrivate bool
+}
+
+func (f *httpFetcher) Fetch(rawUrl string) (data []byte, err error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		err = ErrInvalidUrl
+		return
+	}
+	if !f.hostAllowed(u.Hostname()) {
+		err = ErrForbiddenHost
+		return
+	}
+
+	response, err := f.client.Get(u.String())
+	if err != nil {
+		// client wraps errors of redirect checks and dialing
+		for _, known := range []error{ErrForbiddenHost, ErrTooManyRedirects, ErrInvalidUrl} {
+			if errors.Is(err, known) {
+				err = known
+				break
+			}
+		}
+		return
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode != http.StatusOK {
+		err = errors.New("unexpected response status " + strconv.Itoa(response.StatusCode))
+		return
+	}
+	if response.ContentLength > f.maxSize {
+		err = ErrTooLarge
+		return
+	}
+
+	data, err = io.ReadAll(io.LimitReader(response.Body, f.maxSize+1))
+	if err != nil {
+		return
+	}
+	if int64(len(data)) > f.maxSize {
+		data = nil
+		err = ErrTooLarge
+	}
+	return
+}
+
+// hostAllowed matches a host and its subdomains against deny and allow lists, an empty allow list permits any host
+func (f *httpFetcher) hostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if matchHost(host, f.denyHosts) {
+		return false
+	}
+	return len(f.allowHosts) == 0 || matchHost(host, f.allowHosts)
+}
+
+func (f *httpFetcher) checkRedirect(request *http.Request, via []*http.Request) error {
+	if len(via) > f.maxRedirects {
+		return ErrTooManyRedirects
+	}
+	if request.URL.Scheme != "http" && request.URL.Scheme != "https" {
+		return ErrInvalidUrl
+	}
+	if !f.hostAllowed(request.URL.Hostname()) {
+		return ErrForbiddenHost
+	}
+	return nil
+}
+
+// control checks resolved addresses right before connecting, so that DNS answers could not point to private networks
+func (f *httpFetcher) control(network, address string, _ syscall.RawConn) error {
+	if f.allowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isPrivate(ip) {
+		return ErrForbiddenHost
+	}
+	return nil
+}
+
+func matchHost(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimPrefix(pattern, "."))
+		if host == pattern || strings.HasSuffix(host, "."+pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsPrivate() ||
+		ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// carrier-grade NAT range, RFC 6598
+var sharedAddressSpace = &net.IPNet{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(10, 32)}
+
+func NewHttpFetcher(settings config.Fetch, maxSize int64) Fetcher {
+	f := &httpFetcher{
+		maxSize:      maxSize,
+		maxRedirects: settings.MaxRedirects,
+		allowHosts:   settings.AllowHosts,
+		denyHosts:    settings.DenyHosts,
+		allowPrivate: settings.AllowPrivate,
+	}
+	if settings.MaxSize > 0 {
+		f.maxSize = int64(settings.MaxSize) * 1024 * 1024
+	}
+
+	timeout := settings.Timeout
+	if timeout <= 0 {
+		timeout = TIMEOUT_DEFAULT
+	}
+
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: f.control,
+	}
+	f.client = &http.Client{
+		Timeout:       timeout,
+		CheckRedirect: f.checkRedirect,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConnsPerHost:   2,
+		},
+	}
+	return f
+}
@@ -0,0 +1,158 @@
+package fetcher
+
+import (
+	"errors"
+	"github.com/urvin/gokaru/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestServer serves /data/{size} with a body of the size, /chunked/{size} without Content-Length and
+// /redirect/{count} redirecting count times before /data/4
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/data/{size}", func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.PathValue("size"))
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		_, _ = w.Write([]byte(strings.Repeat("x", size)))
+	})
+	mux.HandleFunc("/chunked/{size}", func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.PathValue("size"))
+		for i := 0; i < size; i++ {
+			_, _ = w.Write([]byte("x"))
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/redirect/{count}", func(w http.ResponseWriter, r *http.Request) {
+		count, _ := strconv.Atoi(r.PathValue("count"))
+		if count <= 1 {
+			http.Redirect(w, r, "/data/4", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/redirect/"+strconv.Itoa(count-1), http.StatusFound)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHttpFetcherFetch(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name     string
+		settings config.Fetch
+		maxSize  int64
+		path     string
+		size     int
+		err      error
+	}{
+		{
+			name:    "private address is blocked by default",
+			maxSize: 1024,
+			path:    "/data/4",
+			err:     ErrForbiddenHost,
+		},
+		{
+			name:     "private address is allowed on opt-in",
+			settings: config.Fetch{AllowPrivate: true},
+			maxSize:  1024,
+			path:     "/data/4",
+			size:     4,
+		},
+		{
+			name:     "denied host",
+			settings: config.Fetch{AllowPrivate: true, DenyHosts: []string{"127.0.0.1"}},
+			maxSize:  1024,
+			path:     "/data/4",
+			err:      ErrForbiddenHost,
+		},
+		{
+			name:     "host out of allow list",
+			settings: config.Fetch{AllowPrivate: true, AllowHosts: []string{"example.com"}},
+			maxSize:  1024,
+			path:     "/data/4",
+			err:      ErrForbiddenHost,
+		},
+		{
+			name:     "redirects within limit",
+			settings: config.Fetch{AllowPrivate: true, MaxRedirects: 3},
+			maxSize:  1024,
+			path:     "/redirect/3",
+			size:     4,
+		},
+		{
+			name:     "redirects over limit",
+			settings: config.Fetch{AllowPrivate: true, MaxRedirects: 3},
+			maxSize:  1024,
+			path:     "/redirect/4",
+			err:      ErrTooManyRedirects,
+		},
+		{
+			name:     "redirects disabled",
+			settings: config.Fetch{AllowPrivate: true},
+			maxSize:  1024,
+			path:     "/redirect/1",
+			err:      ErrTooManyRedirects,
+		},
+		{
+			name:     "size at limit",
+			settings: config.Fetch{AllowPrivate: true},
+			maxSize:  16,
+			path:     "/data/16",
+			size:     16,
+		},
+		{
+			name:     "declared size over limit",
+			settings: config.Fetch{AllowPrivate: true},
+			maxSize:  16,
+			path:     "/data/17",
+			err:      ErrTooLarge,
+		},
+		{
+			name:     "streamed size over limit",
+			settings: config.Fetch{AllowPrivate: true},
+			maxSize:  16,
+			path:     "/chunked/17",
+			err:      ErrTooLarge,
+		},
+		{
+			name:     "unexpected status",
+			settings: config.Fetch{AllowPrivate: true},
+			maxSize:  1024,
+			path:     "/missing",
+			err:      errors.New("unexpected response status 404"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := NewHttpFetcher(test.settings, test.maxSize)
+			data, err := f.Fetch(server.URL + test.path)
+
+			switch {
+			case test.err == nil && err != nil:
+				t.Fatalf("unexpected error %v", err)
+			case test.err != nil && (err == nil || err.Error() != test.err.Error()):
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			case test.err == nil && len(data) != test.size:
+				t.Fatalf("expected %d bytes, got %d", test.size, len(data))
+			}
+		})
+	}
+}
+
+func TestHttpFetcherInvalidUrl(t *testing.T) {
+	f := NewHttpFetcher(config.Fetch{AllowPrivate: true}, 1024)
+	for _, rawUrl := range []string{"", "ftp://example.com/image.png", "http:///image.png", "file:///etc/passwd"} {
+		if _, err := f.Fetch(rawUrl); !errors.Is(err, ErrInvalidUrl) {
+			t.Errorf("%q: expected %v, got %v", rawUrl, ErrInvalidUrl, err)
+		}
+	}
+}
@@ -2,12 +2,18 @@ package helper
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"os/exec"
 )
 
 func Exec(name string, arg ...string) (output string, err error) {
-	cmd := exec.Command(name, arg...)
+	return ExecContext(context.Background(), name, arg...)
+}
+
+// ExecContext runs a command killing it when ctx is done
+func ExecContext(ctx context.Context, name string, arg ...string) (output string, err error) {
+	cmd := exec.CommandContext(ctx, name, arg...)
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
@@ -15,7 +21,13 @@ func Exec(name string, arg ...string) (output string, err error) {
 	er := cmd.Run()
 	stdOutput, errOutput := string(stdout.Bytes()), string(stderr.Bytes())
 	if er != nil {
-		err = errors.New(errOutput)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if errOutput == "" {
+			err = er
+		} else {
+			err = errors.New(errOutput)
+		}
 		return
 	}
 
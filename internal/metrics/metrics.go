@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const TYPE_COUNTER = "counter"
+const TYPE_GAUGE = "gauge"
+
+type series struct {
+	labels string
+	value  float64
+	fn     func() float64
+}
+
+type family struct {
+	kind   string
+	help   string
+	series map[string]*series
+}
+
+var (
+	mx       sync.Mutex
+	families = make(map[string]*family)
+)
+
+// Register describes a metric, metrics are created on the first use anyway
+func Register(name, kind, help string) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	f := get(name, kind)
+	f.help = help
+}
+
+// RegisterFunc adds a gauge calculated on every scrape, labels are name and value pairs
+func RegisterFunc(name, help string, fn func() float64, labels ...string) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	f := get(name, TYPE_GAUGE)
+	f.help = help
+	f.get(labels).fn = fn
+}
+
+// Add increments a counter, labels are name and value pairs
+func Add(name string, delta float64, labels ...string) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	get(name, TYPE_COUNTER).get(labels).value += delta
+}
+
+// Set changes a gauge value, labels are name and value pairs
+func Set(name string, value float64, labels ...string) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	get(name, TYPE_GAUGE).get(labels).value = value
+}
+
+// Write outputs all metrics in prometheus text format
+func Write(w io.Writer) (err error) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		f := families[name]
+		if f.help != "" {
+			b.WriteString("# HELP " + name + " " + f.help + "\n")
+		}
+		b.WriteString("# TYPE " + name + " " + f.kind + "\n")
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := f.series[key].value
+			if f.series[key].fn != nil {
+				value = f.series[key].fn()
+			}
+			b.WriteString(name + f.series[key].labels + " " + format(value) + "\n")
+		}
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return
+}
+
+func get(name, kind string) *family {
+	f := families[name]
+	if f == nil {
+		f = &family{kind: kind, series: make(map[string]*series)}
+		families[name] = f
+	}
+	return f
+}
+
+func (f *family) get(labels []string) *series {
+	key := strings.Join(labels, "\x00")
+	s := f.series[key]
+	if s == nil {
+		s = &series{labels: formatLabels(labels)}
+		f.series[key] = s
+	}
+	return s
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, labels[i]+"="+strconv.Quote(labels[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func format(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"encoding/json"
+	"github.com/urvin/gokaru/internal/contracts"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+const JOURNAL_FILENAME = "journal.db"
+
+var (
+	journalPendingBucket = []byte("pending")
+	journalFailedBucket  = []byte("failed")
+)
+
+// JournalRecord is a post-processing job of a thumbnail
+type JournalRecord struct {
+	Miniature   contracts.MiniatureDto `json:"miniature"`
+	Attempts    int                    `json:"attempts"`
+	NextAttempt time.Time              `json:"next_attempt"`
+	Error       string                 `json:"error,omitempty"`
+	FailedAt    time.Time              `json:"failed_at,omitempty"`
+}
+
+// Journal persists post-processing jobs, so that they survive restarts, and keeps repeatedly failed ones apart
+type Journal struct {
+	db *bolt.DB
+}
+
+func NewJournal(fileName string) (j *Journal, err error) {
+	db, err := bolt.Open(fileName, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(journalPendingBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(journalFailedBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return
+	}
+	j = &Journal{db: db}
+	return
+}
+
+// put adds or replaces a pending job of a thumbnail
+func (j *Journal) put(record JournalRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return j.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalPendingBucket).Put([]byte(record.Miniature.Hash()), value)
+	})
+}
+
+func (j *Journal) remove(miniature *contracts.MiniatureDto) error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalPendingBucket).Delete([]byte(miniature.Hash()))
+	})
+}
+
+// fail moves a job to the failed list
+func (j *Journal) fail(record JournalRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return j.db.Update(func(tx *bolt.Tx) error {
+		key := []byte(record.Miniature.Hash())
+		if err := tx.Bucket(journalPendingBucket).Delete(key); err != nil {
+			return err
+		}
+		return tx.Bucket(journalFailedBucket).Put(key, value)
+	})
+}
+
+func (j *Journal) Pending() ([]JournalRecord, error) {
+	return j.list(journalPendingBucket)
+}
+
+func (j *Journal) Failed() ([]JournalRecord, error) {
+	return j.list(journalFailedBucket)
+}
+
+func (j *Journal) list(bucket []byte) (records []JournalRecord, err error) {
+	records = make([]JournalRecord, 0)
+	err = j.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(_, value []byte) error {
+			var record JournalRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	return
+}
+
+func (j *Journal) Close() error {
+	return j.db.Close()
+}
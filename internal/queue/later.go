@@ -0,0 +1,318 @@
+package queue
+
+import (
+	"context"
+	"github.com/urvin/gokaru/internal/contracts"
+	"github.com/urvin/gokaru/internal/metrics"
+	"os"
+	"strconv"
+	"time"
+)
+
+const LATER_ATTEMPTS_MAX = 5
+const LATER_BACKOFF = 30 * time.Second
+const LATER_BACKOFF_MAX = 30 * time.Minute
+
+// later is a post-processing job of a stored thumbnail, guarded by Queue.latersMx
+type later struct {
+	miniature  contracts.MiniatureDto
+	origin     *originState
+	generation uint64
+	attempts   int
+	// queued in latersProcs, or waiting for a retry when delayed
+	queued  bool
+	delayed bool
+	running bool
+}
+
+// postpone journals a post-processing job of a thumbnail, a job of the same thumbnail is replaced,
+// the origin hold is released by the job
+func (q *Queue) postpone(miniature *contracts.MiniatureDto, state *originState, generation uint64) {
+	err := q.journal.put(JournalRecord{Miniature: *miniature})
+	if err != nil {
+		q.logger.Error(
+			"Could not journal post-processing of "+miniature.Hash(),
+			"context", "queue",
+			"handler", "postpone",
+			"error", err.Error(),
+		)
+	}
+
+	q.latersMx.Lock()
+	defer q.latersMx.Unlock()
+
+	ltr := &later{
+		miniature:  *miniature,
+		origin:     state,
+		generation: generation,
+	}
+	q.enqueueLater(ltr, 0)
+}
+
+// replayLaters enqueues journaled jobs left by a previous run
+func (q *Queue) replayLaters() {
+	records, err := q.journal.Pending()
+	if err != nil {
+		q.logger.Error(
+			"Could not read post-processing journal",
+			"context", "queue",
+			"handler", "replayLaters",
+			"error", err.Error(),
+		)
+		return
+	}
+
+	q.latersMx.Lock()
+	defer q.latersMx.Unlock()
+
+	for _, record := range records {
+		origin := originOf(&record.Miniature)
+		state := q.holdOrigin(origin.Hash())
+		ltr := &later{
+			miniature:  record.Miniature,
+			origin:     state,
+			generation: q.originGeneration(state),
+			attempts:   record.Attempts,
+		}
+		q.enqueueLater(ltr, time.Until(record.NextAttempt))
+	}
+
+	if len(records) > 0 {
+		q.logger.Info(
+			"Replayed "+strconv.Itoa(len(records))+" post-processing jobs",
+			"context", "queue",
+			"handler", "replayLaters",
+		)
+	}
+}
+
+// enqueueLater registers a job to run after delay, a pending job of the same thumbnail is replaced and runs once
+func (q *Queue) enqueueLater(ltr *later, delay time.Duration) {
+	key := ltr.miniature.Hash()
+
+	if q.latersClosed {
+		// the job stays in the journal for the next run
+		q.releaseOrigin(originOf(&ltr.miniature).Hash(), ltr.origin)
+		return
+	}
+
+	if old := q.laters[key]; old != nil {
+		q.releaseOrigin(originOf(&old.miniature).Hash(), old.origin)
+		q.laters[key] = ltr
+		if old.running || old.queued && !old.delayed {
+			// the running one restarts it when done, the queued one runs it
+			ltr.queued = old.queued
+			return
+		}
+		// a retry timer of the old job is left to fire for nothing
+	}
+	q.laters[key] = ltr
+	q.pushLater(key, ltr, delay)
+}
+
+func (q *Queue) pushLater(key string, ltr *later, delay time.Duration) {
+	ltr.queued = true
+	if delay <= 0 {
+		go func() {
+			q.latersProcs <- key
+		}()
+		return
+	}
+
+	ltr.delayed = true
+	time.AfterFunc(delay, func() {
+		q.latersMx.Lock()
+		defer q.latersMx.Unlock()
+
+		if q.latersClosed || q.laters[key] != ltr {
+			return
+		}
+		ltr.delayed = false
+		go func() {
+			q.latersProcs <- key
+		}()
+	})
+}
+
+func (q *Queue) processLaters() {
+	for key := range q.latersProcs {
+		q.latersMx.Lock()
+		ltr := q.laters[key]
+		ltr.queued = false
+		ltr.running = true
+		q.latersMx.Unlock()
+
+		err := q.processLater(ltr)
+		q.finishLater(key, ltr, err)
+	}
+}
+
+// finishLater forgets a done job, or schedules a retry of a failed one until it is moved to the failed list
+func (q *Queue) finishLater(key string, ltr *later, err error) {
+	q.latersMx.Lock()
+	defer q.latersMx.Unlock()
+
+	ltr.running = false
+
+	if current := q.laters[key]; current != ltr {
+		// replaced meanwhile
+		if !current.queued {
+			q.pushLater(key, current, 0)
+		}
+		return
+	}
+
+	if err == nil {
+		metrics.Add("gokaru_postprocess_total", 1, "status", "done")
+		delete(q.laters, key)
+		q.releaseOrigin(originOf(&ltr.miniature).Hash(), ltr.origin)
+		err = q.journal.remove(&ltr.miniature)
+		if err != nil {
+			q.logger.Error(
+				"Could not remove post-processing of "+key+" from journal",
+				"context", "queue",
+				"handler", "finishLater",
+				"error", err.Error(),
+			)
+		}
+		return
+	}
+
+	ltr.attempts++
+	record := JournalRecord{
+		Miniature: ltr.miniature,
+		Attempts:  ltr.attempts,
+		Error:     err.Error(),
+	}
+
+	if ltr.attempts >= LATER_ATTEMPTS_MAX {
+		metrics.Add("gokaru_postprocess_total", 1, "status", "failed")
+		q.logger.Error(
+			"Post-processing of "+key+" failed "+strconv.Itoa(ltr.attempts)+" times, giving up",
+			"context", "queue",
+			"handler", "finishLater",
+			"error", err.Error(),
+		)
+		delete(q.laters, key)
+		q.releaseOrigin(originOf(&ltr.miniature).Hash(), ltr.origin)
+		record.FailedAt = time.Now()
+		err = q.journal.fail(record)
+	} else {
+		metrics.Add("gokaru_postprocess_total", 1, "status", "retried")
+		q.logger.Warn(
+			"Post-processing of "+key+" failed, retrying",
+			"context", "queue",
+			"handler", "finishLater",
+			"error", err.Error(),
+		)
+		delay := min(LATER_BACKOFF<<(ltr.attempts-1), LATER_BACKOFF_MAX)
+		record.NextAttempt = time.Now().Add(delay)
+		q.pushLater(key, ltr, delay)
+		err = q.journal.put(record)
+	}
+	if err != nil {
+		q.logger.Error(
+			"Could not journal post-processing of "+key,
+			"context", "queue",
+			"handler", "finishLater",
+			"error", err.Error(),
+		)
+	}
+}
+
+// processLater post-processes a thumbnail unless its origin or the thumbnail itself is gone
+func (q *Queue) processLater(ltr *later) (err error) {
+	start := time.Now()
+
+	if q.originGeneration(ltr.origin) != ltr.generation {
+		return
+	}
+
+	fn := q.thumbnailer.Later(q.thumbnailOptions(&ltr.miniature))
+	if fn == nil {
+		return
+	}
+
+	file, err := q.storage.ReadThumbnail(stored(&ltr.miniature))
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	data, err := fn(file.Contents)
+	if err != nil {
+		return
+	}
+
+	committed, err := q.commitOrigin(ltr.origin, ltr.generation, func() error {
+		return q.storage.WriteThumbnail(stored(&ltr.miniature), data, file.Quality)
+	})
+	if err != nil || !committed {
+		return
+	}
+
+	q.logger.Info(
+		"Postprocessed "+ltr.miniature.Hash()+" in "+time.Since(start).String(),
+		"context", "queue",
+		"handler", "processLater",
+	)
+
+	return
+}
+
+// latersBacklog counts jobs, only ones queued or running if withDelayed is false
+func (q *Queue) latersBacklog(withDelayed bool) (count int) {
+	q.latersMx.Lock()
+	defer q.latersMx.Unlock()
+
+	for _, ltr := range q.laters {
+		if ltr.running || ltr.queued && (withDelayed || !ltr.delayed) {
+			count++
+		}
+	}
+	return
+}
+
+// closeLaters stops taking jobs and waits for queued and running ones, delayed retries and jobs left after ctx is
+// done stay in the journal
+func (q *Queue) closeLaters(ctx context.Context) error {
+	q.latersMx.Lock()
+	q.latersClosed = true
+	q.latersMx.Unlock()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if q.latersBacklog(false) == 0 {
+			close(q.latersProcs)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			q.logger.Warn(
+				"Left "+strconv.Itoa(q.latersBacklog(true))+" post-processing jobs in journal",
+				"context", "queue",
+				"handler", "close",
+			)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func originOf(miniature *contracts.MiniatureDto) *contracts.OriginDto {
+	return &contracts.OriginDto{
+		Type:     miniature.Type,
+		Category: miniature.Category,
+		Name:     miniature.Name,
+	}
+}
+
+// FailedLaters lists post-processing jobs given up after LATER_ATTEMPTS_MAX attempts
+func (q *Queue) FailedLaters() ([]JournalRecord, error) {
+	return q.journal.Failed()
+}
@@ -1,179 +1,605 @@
 package queue
 
 import (
+	"cmp"
+	"context"
+	"errors"
+	"github.com/urvin/gokaru/internal/config"
 	"github.com/urvin/gokaru/internal/contracts"
+	"github.com/urvin/gokaru/internal/metrics"
 	strg "github.com/urvin/gokaru/internal/storage"
 	thmbnlr "github.com/urvin/gokaru/internal/thumbnailer"
 	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+const BACKGROUND_BACKLOG_SIZE = 4096
+
+var (
+	ErrQueueFull   = errors.New("thumbnail queue is full")
+	ErrWaitTimeout = errors.New("thumbnail waited too long in queue")
+	ErrJobTimeout  = errors.New("thumbnail processing took too long")
+	ErrClosed      = errors.New("thumbnail queue is closed")
+)
+
 type entry struct {
+	started   chan struct{}
 	ready     chan struct{}
 	err       error
 	thumbnail contracts.FileDto
 	miniature *contracts.MiniatureDto
+
+	// guarded by Queue.entriesMx
+	waiters   int
+	running   bool
+	cancelled bool
 }
 
-type later struct {
-	fn        func([]byte) ([]byte, error)
-	miniature contracts.MiniatureDto
+// originState tracks an origin with thumbnails in progress, generation changes on every origin overwrite
+type originState struct {
+	mx         sync.Mutex
+	jobs       int
+	generation uint64
 }
 
 type Queue struct {
 	entriesMx sync.Mutex
 	entries   map[string]*entry
 
-	entriesProcs chan *entry
-	latersProcs  chan later
+	originsMx sync.Mutex
+	origins   map[string]*originState
+
+	scheduler    *scheduler
+	workers      sync.WaitGroup
+	latersProcs  chan string
+	latersMx     sync.Mutex
+	laters       map[string]*later
+	latersClosed bool
+	journal      *Journal
+
+	wait       time.Duration
+	jobTimeout time.Duration
 
 	logger      *slog.Logger
 	storage     strg.Storage
 	thumbnailer thmbnlr.Thumbnailer
 }
 
-func NewQueue(logger *slog.Logger, storage strg.Storage, thumbnailer thmbnlr.Thumbnailer, procs uint, postProcs uint) *Queue {
+// Limits of a queue: Size of pending requested thumbnails, Wait for a worker and JobTimeout of processing, zero
+// durations are unlimited, Reserved workers per priority
+type Limits struct {
+	Size       uint
+	Wait       time.Duration
+	JobTimeout time.Duration
+	Reserved   map[Priority]uint
+}
+
+func NewQueue(logger *slog.Logger, storage strg.Storage, thumbnailer thmbnlr.Thumbnailer, journal *Journal, procs uint, postProcs uint, limits Limits) *Queue {
+	sizes := map[Priority]int{
+		PRIORITY_INTERACTIVE: int(limits.Size),
+		PRIORITY_WARMUP:      BACKGROUND_BACKLOG_SIZE,
+		PRIORITY_BATCH:       BACKGROUND_BACKLOG_SIZE,
+	}
+
 	q := &Queue{
-		entries:      make(map[string]*entry),
-		logger:       logger,
-		storage:      storage,
-		thumbnailer:  thumbnailer,
-		entriesProcs: make(chan *entry, procs),
-		latersProcs:  make(chan later, postProcs),
+		entries:     make(map[string]*entry),
+		origins:     make(map[string]*originState),
+		logger:      logger,
+		storage:     storage,
+		thumbnailer: thumbnailer,
+		scheduler:   newScheduler(procs, sizes, limits.Reserved),
+		latersProcs: make(chan string, postProcs),
+		laters:      make(map[string]*later),
+		journal:     journal,
+		wait:        limits.Wait,
+		jobTimeout:  limits.JobTimeout,
 	}
+
+	for _, p := range priorities {
+		metrics.RegisterFunc("gokaru_queue_pending", "Thumbnails waiting for a worker by priority", func() float64 {
+			return float64(q.scheduler.pending(p))
+		}, "priority", p.String())
+		metrics.RegisterFunc("gokaru_queue_running", "Thumbnails being processed by priority", func() float64 {
+			return float64(q.scheduler.running(p))
+		}, "priority", p.String())
+	}
+	metrics.Register("gokaru_queue_rejected_total", metrics.TYPE_COUNTER, "Thumbnail requests given up by reason")
+	metrics.Register("gokaru_queue_cancelled_total", metrics.TYPE_COUNTER, "Pending thumbnails left by every client")
+
+	metrics.RegisterFunc("gokaru_warmup_backlog", "Thumbnails waiting for warmup or being warmed up", func() float64 {
+		return float64(q.scheduler.pending(PRIORITY_WARMUP)+q.scheduler.pending(PRIORITY_BATCH)) +
+			float64(q.scheduler.running(PRIORITY_WARMUP)+q.scheduler.running(PRIORITY_BATCH))
+	})
+	metrics.Register("gokaru_warmup_total", metrics.TYPE_COUNTER, "Thumbnail warmups by priority and status")
+
+	metrics.RegisterFunc("gokaru_postprocess_backlog", "Thumbnails waiting for post-processing or being post-processed", func() float64 {
+		return float64(q.latersBacklog(false))
+	})
+	metrics.Register("gokaru_postprocess_total", metrics.TYPE_COUNTER, "Post-processing attempts by status")
+
 	var i uint
+	q.workers.Add(int(procs))
 	for i = 0; i < procs; i++ {
-		go q.processEntries()
+		go q.processJobs()
 	}
 	for i = 0; i < postProcs; i++ {
 		go q.processLaters()
 	}
+	q.replayLaters()
 	return q
 }
-func (q *Queue) GetThumbnail(miniature *contracts.MiniatureDto) (thumbnail contracts.FileDto, err error) {
 
+// GetThumbnail waits for a thumbnail to be read or created, waiting for a worker is limited by the queue wait time
+// and ctx, a pending thumbnail is cancelled when all its waiters are gone
+func (q *Queue) GetThumbnail(ctx context.Context, miniature *contracts.MiniatureDto) (thumbnail contracts.FileDto, err error) {
 	key := miniature.Hash()
 
 	q.entriesMx.Lock()
 	e := q.entries[key]
-
 	if e == nil {
-		e = &entry{
-			ready:     make(chan struct{}),
-			miniature: miniature,
+		e = newEntry(miniature)
+		if !q.scheduler.push(&job{priority: PRIORITY_INTERACTIVE, entry: e}) {
+			q.entriesMx.Unlock()
+			if q.scheduler.isClosed() {
+				err = ErrClosed
+				return
+			}
+			metrics.Add("gokaru_queue_rejected_total", 1, "reason", "full")
+			err = ErrQueueFull
+			return
 		}
 		q.entries[key] = e
-		q.entriesMx.Unlock()
+	}
+	e.waiters++
+	q.entriesMx.Unlock()
 
-		q.entriesProcs <- e
-		<-e.ready
-	} else {
-		q.entriesMx.Unlock()
-		<-e.ready
+	defer q.leave(key, e)
+
+	waitCtx := ctx
+	if q.wait > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, q.wait)
+		defer cancel()
+	}
+
+	select {
+	case <-e.started:
+	case <-waitCtx.Done():
+		err = ctx.Err()
+		if err == nil {
+			metrics.Add("gokaru_queue_rejected_total", 1, "reason", "wait")
+			err = ErrWaitTimeout
+		}
+		return
+	}
+
+	var timeout <-chan time.Time
+	if q.jobTimeout > 0 {
+		timer := time.NewTimer(q.jobTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-e.ready:
+	case <-timeout:
+		// vips could not be interrupted, the thumbnail is still stored when ready
+		metrics.Add("gokaru_queue_rejected_total", 1, "reason", "timeout")
+		err = ErrJobTimeout
+		return
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
 	}
 
 	err = e.err
 	thumbnail = e.thumbnail
+	return
+}
+
+// Timeout is the longest time a requested thumbnail could be waited for, 0 if unlimited
+func (q *Queue) Timeout() time.Duration {
+	if q.wait <= 0 || q.jobTimeout <= 0 {
+		return 0
+	}
+	return q.wait + q.jobTimeout
+}
+
+// RetryAfter suggests clients a delay before repeating rejected requests
+func (q *Queue) RetryAfter() time.Duration {
+	if q.wait > time.Second {
+		return q.wait
+	}
+	return time.Second
+}
+
+func newEntry(miniature *contracts.MiniatureDto) *entry {
+	return &entry{
+		started:   make(chan struct{}),
+		ready:     make(chan struct{}),
+		miniature: miniature,
+	}
+}
 
+// leave unregisters a waiter, the last one cancels the thumbnail if it has not been started yet
+func (q *Queue) leave(key string, e *entry) {
 	q.entriesMx.Lock()
-	delete(q.entries, key)
-	q.entriesMx.Unlock()
+	defer q.entriesMx.Unlock()
+
+	e.waiters--
+	if e.waiters > 0 || e.running || e.cancelled {
+		return
+	}
+
+	e.cancelled = true
+	if q.entries[key] == e {
+		delete(q.entries, key)
+	}
+	// a worker could have taken it already, it skips cancelled entries then
+	q.scheduler.remove(e)
+	metrics.Add("gokaru_queue_cancelled_total", 1)
+}
+
+// Warmup enqueues a thumbnail to be created in background soon, false if the backlog is full
+func (q *Queue) Warmup(miniature *contracts.MiniatureDto) bool {
+	return q.background(miniature, PRIORITY_WARMUP)
+}
+
+// Batch enqueues a thumbnail to be created in background when nothing else is waiting, false if the backlog is full
+func (q *Queue) Batch(miniature *contracts.MiniatureDto) bool {
+	return q.background(miniature, PRIORITY_BATCH)
+}
 
+func (q *Queue) background(miniature *contracts.MiniatureDto, priority Priority) bool {
+	if !q.scheduler.push(&job{priority: priority, miniature: miniature}) {
+		metrics.Add("gokaru_warmup_total", 1, "priority", priority.String(), "status", "dropped")
+		return false
+	}
+	metrics.Add("gokaru_warmup_total", 1, "priority", priority.String(), "status", "queued")
+	return true
+}
+
+// Close stops taking thumbnails, lets requested ones finish and drains post-processing until ctx is done,
+// background thumbnails not started yet are dropped
+func (q *Queue) Close(ctx context.Context) (err error) {
+	dropped := q.scheduler.close()
+	if dropped > 0 {
+		q.logger.Warn(
+			"Dropped "+strconv.Itoa(dropped)+" background thumbnails",
+			"context", "queue",
+			"handler", "close",
+		)
+	}
+
+	err = wait(ctx, &q.workers)
+	if err != nil {
+		return
+	}
+
+	err = q.closeLaters(ctx)
+	if err != nil {
+		return
+	}
+	err = q.journal.Close()
 	return
 }
 
-func (q *Queue) processEntries() {
-	for e := range q.entriesProcs {
-		e.thumbnail, e.err = q.obtainThumbnail(e.miniature)
-		close(e.ready)
+func wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) processJobs() {
+	defer q.workers.Done()
+
+	for {
+		j := q.scheduler.next()
+		if j == nil {
+			return
+		}
+		if j.entry != nil {
+			q.processEntry(j.entry)
+		} else {
+			q.warmup(j.miniature, j.priority)
+		}
+		q.scheduler.done(j)
+	}
+}
+
+func (q *Queue) processEntry(e *entry) {
+	q.entriesMx.Lock()
+	if e.cancelled {
+		q.entriesMx.Unlock()
+		return
+	}
+	e.running = true
+	close(e.started)
+	q.entriesMx.Unlock()
+
+	q.runEntry(e)
+}
+
+// runEntry creates a started thumbnail and lets its waiters go
+func (q *Queue) runEntry(e *entry) {
+	e.thumbnail, e.err = q.obtainThumbnail(e.miniature)
+
+	q.entriesMx.Lock()
+	defer q.entriesMx.Unlock()
+
+	key := e.miniature.Hash()
+	if q.entries[key] == e {
+		delete(q.entries, key)
+	}
+	close(e.ready)
+}
+
+// warmup creates a thumbnail unless it is being requested at the moment, requests of it meanwhile wait for the warmup
+func (q *Queue) warmup(miniature *contracts.MiniatureDto, priority Priority) {
+	key := miniature.Hash()
+
+	q.entriesMx.Lock()
+	if q.entries[key] != nil {
+		q.entriesMx.Unlock()
+		metrics.Add("gokaru_warmup_total", 1, "priority", priority.String(), "status", "skipped")
+		return
 	}
+	e := newEntry(miniature)
+	e.running = true
+	close(e.started)
+	q.entries[key] = e
+	q.entriesMx.Unlock()
+
+	q.runEntry(e)
+
+	if e.err != nil {
+		metrics.Add("gokaru_warmup_total", 1, "priority", priority.String(), "status", "failed")
+		q.logger.Error(
+			"Could not warm up "+key,
+			"context", "queue",
+			"handler", "warmup",
+			"error", e.err.Error(),
+		)
+		return
+	}
+	metrics.Add("gokaru_warmup_total", 1, "priority", priority.String(), "status", "done")
 }
 
 func (q *Queue) obtainThumbnail(miniature *contracts.MiniatureDto) (thumbnail contracts.FileDto, err error) {
-	if q.storage.ThumbnailExists(miniature) {
-		thumbnail, err = q.storage.ReadThumbnail(miniature)
+	if q.storage.ThumbnailExists(stored(miniature)) {
+		thumbnail, err = q.storage.ReadThumbnail(stored(miniature))
 		return
 	}
 	thumbnail, err = q.processThumbnail(miniature)
 	return
 }
 
+// Replace runs fn, which overwrites, moves or removes origins, so that no thumbnail of the previous images
+// survives it: jobs started earlier are not stored, new jobs wait for fn to finish
+func (q *Queue) Replace(fn func() error, origins ...*contracts.OriginDto) (err error) {
+	keys := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		if !slices.Contains(keys, origin.Hash()) {
+			keys = append(keys, origin.Hash())
+		}
+	}
+	// lock in the same order everywhere to avoid deadlocks of concurrent replaces
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		state := q.holdOrigin(key)
+		defer q.releaseOrigin(key, state)
+
+		state.mx.Lock()
+		defer state.mx.Unlock()
+		state.generation++
+	}
+
+	err = fn()
+	return
+}
+
+func (q *Queue) holdOrigin(key string) *originState {
+	q.originsMx.Lock()
+	defer q.originsMx.Unlock()
+
+	state := q.origins[key]
+	if state == nil {
+		state = &originState{}
+		q.origins[key] = state
+	}
+	state.jobs++
+	return state
+}
+
+func (q *Queue) releaseOrigin(key string, state *originState) {
+	q.originsMx.Lock()
+	defer q.originsMx.Unlock()
+
+	state.jobs--
+	if state.jobs == 0 {
+		delete(q.origins, key)
+	}
+}
+
+func (q *Queue) originGeneration(state *originState) uint64 {
+	state.mx.Lock()
+	defer state.mx.Unlock()
+	return state.generation
+}
+
+// commitOrigin runs fn only if the origin has not been overwritten since the generation was taken
+func (q *Queue) commitOrigin(state *originState, generation uint64, fn func() error) (committed bool, err error) {
+	state.mx.Lock()
+	defer state.mx.Unlock()
+
+	if state.generation != generation {
+		return
+	}
+	committed = true
+	err = fn()
+	return
+}
+
+// OriginInfo returns facts of an image origin, it is inspected once and the facts are stored until the origin changes
+func (q *Queue) OriginInfo(origin *contracts.OriginDto) (info contracts.OriginInfoDto, err error) {
+	info, err = q.storage.ReadOriginInfo(origin)
+	if !os.IsNotExist(err) {
+		return
+	}
+
+	state := q.holdOrigin(origin.Hash())
+	defer q.releaseOrigin(origin.Hash(), state)
+	generation := q.originGeneration(state)
+
+	data, err := q.storage.Read(origin)
+	if err != nil {
+		return
+	}
+
+	image, err := q.thumbnailer.Inspect(data.Contents)
+	if err != nil {
+		return
+	}
+	info = contracts.OriginInfoDto{
+		Width:    image.Width,
+		Height:   image.Height,
+		Format:   thmbnlr.AutoFormat(image),
+		Animated: image.Animated,
+		Alpha:    image.Alpha,
+		Palette:  image.Palette,
+	}
+
+	// facts of an overwritten origin are returned, but not stored
+	_, err = q.commitOrigin(state, generation, func() error {
+		return q.storage.WriteOriginInfo(origin, info)
+	})
+	return
+}
+
 func (q *Queue) processThumbnail(miniature *contracts.MiniatureDto) (thumbnail contracts.FileDto, err error) {
 	origin := contracts.OriginDto{
 		Type:     miniature.Type,
 		Category: miniature.Category,
 		Name:     miniature.Name,
 	}
+
+	state := q.holdOrigin(origin.Hash())
+	defer q.releaseOrigin(origin.Hash(), state)
+	generation := q.originGeneration(state)
+
 	originInfo, err := q.storage.Read(&origin)
 	if err != nil {
 		return
 	}
 
-	options := thmbnlr.ThumbnailOptions{}
-	options.SetWidth(uint(miniature.Width))
-	options.SetHeight(uint(miniature.Height))
-	options.SetImageTypeWithExtension(miniature.Extension)
-	options.SetOptionsWithCast(uint(miniature.Cast))
-
-	bytes, ltr, err := q.thumbnailer.Thumbnail(originInfo.Contents, options)
+	bytes, quality, ltr, err := q.thumbnailer.Thumbnail(originInfo.Contents, q.thumbnailOptions(miniature))
 
 	if err != nil {
 		return
 	}
 
-	err = q.storage.WriteThumbnail(miniature, bytes)
+	committed, err := q.commitOrigin(state, generation, func() error {
+		return q.storage.WriteThumbnail(stored(miniature), bytes, quality)
+	})
 	if err != nil {
 		return
 	}
 
 	thumbnail.Contents = bytes
+	thumbnail.Quality = quality
+
+	if !committed {
+		q.logger.Warn(
+			"Origin was overwritten while processing "+miniature.Hash()+", thumbnail is not stored",
+			"context", "queue",
+			"handler", "processThumbnail",
+		)
+		return
+	}
 
 	if ltr != nil {
-		go func(ltr later) {
-			q.latersProcs <- ltr
-		}(later{
-			fn:        ltr,
-			miniature: *miniature,
-		})
+		q.postpone(miniature, q.holdOrigin(origin.Hash()), generation)
 	}
 
 	return
 }
 
-func (q *Queue) processLaters() {
-	for ltr := range q.latersProcs {
-		err := q.processLater(ltr)
-		if err != nil {
-			q.logger.Error(
-				"Could not process later",
-				"context", "queue",
-				"handler", "processLaters",
-				"error", err.Error(),
-			)
-		}
+// trimSettings resolves trim settings of a thumbnail, set overrides of a request win over the category ones, those
+// win over defaults
+func trimSettings(trim contracts.TrimDto, category config.Trim) (threshold float64, color string, padding uint) {
+	threshold = thmbnlr.TRIM_THRESHOLD_DEFAULT
+	if category.Threshold != nil {
+		threshold = *category.Threshold
+	}
+	if trim.Threshold != nil {
+		threshold = *trim.Threshold
 	}
-}
 
-func (q *Queue) processLater(ltr later) (err error) {
-	start := time.Now()
+	color = cmp.Or(trim.Color, category.Color)
 
-	file, err := q.storage.ReadThumbnail(&ltr.miniature)
-	if err != nil {
-		return
+	padding = config.Get().Padding
+	if category.Padding != nil {
+		padding = *category.Padding
 	}
-	data, err := ltr.fn(file.Contents)
-	if err != nil {
-		return
+	if trim.Padding != nil {
+		padding = uint(*trim.Padding)
 	}
+	return
+}
 
-	err = q.storage.WriteThumbnail(&ltr.miniature, data)
+// stored describes a thumbnail in storage: trimmed ones keep resolved trim settings, so that changed category or
+// global settings are not served from thumbnails rendered with previous ones
+func stored(miniature *contracts.MiniatureDto) *contracts.MiniatureDto {
+	if uint(miniature.Cast)&thmbnlr.CAST_TRIM == 0 {
+		return miniature
+	}
 
-	q.logger.Info(
-		"Postprocessed "+ltr.miniature.Hash()+" in "+time.Since(start).String(),
-		"context", "queue",
-		"handler", "processLater",
-	)
+	threshold, color, padding := trimSettings(miniature.Trim, config.Get().Category(miniature.Category).Trim)
+	trimPadding := int(padding)
+	resolved := *miniature
+	resolved.Trim = contracts.TrimDto{
+		Threshold: &threshold,
+		Color:     cmp.Or(color, contracts.TRIM_COLOR_AUTO),
+		Padding:   &trimPadding,
+	}
+	return &resolved
+}
 
+func (q *Queue) thumbnailOptions(miniature *contracts.MiniatureDto) (options thmbnlr.ThumbnailOptions) {
+	options.SetWidth(uint(miniature.Width))
+	options.SetHeight(uint(miniature.Height))
+	options.SetImageTypeWithExtension(miniature.Extension)
+	options.SetOptionsWithCast(uint(miniature.Cast))
+	options.SetCategory(miniature.Category)
+	category := config.Get().Category(miniature.Category)
+	options.SetTrimSettings(trimSettings(miniature.Trim, category.Trim))
+	metadata := category.Metadata
+	if miniature.Preserve.Metadata == contracts.METADATA_NONE {
+		metadata = nil
+	} else if miniature.Preserve.Metadata != "" {
+		metadata = strings.Split(miniature.Preserve.Metadata, ",")
+	}
+	options.SetPreserve(cmp.Or(miniature.Preserve.Profile, category.Profile), metadata)
+	if preset, ok := config.Get().Preset(miniature.Preset); ok {
+		options.SetQuality(preset.Quality)
+		options.SetBlur(preset.Filters.Blur)
+		options.SetSharpen(preset.Filters.Sharpen)
+	}
 	return
 }
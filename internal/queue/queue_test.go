@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/urvin/gokaru/internal/contracts"
+	strg "github.com/urvin/gokaru/internal/storage"
+	thmbnlr "github.com/urvin/gokaru/internal/thumbnailer"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestGetThumbnailCancelledFreesQueue(t *testing.T) {
+	// without workers requests could only leave the queue
+	q := &Queue{
+		entries:   make(map[string]*entry),
+		scheduler: newScheduler(0, map[Priority]int{PRIORITY_INTERACTIVE: 2}, nil),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := range 10 {
+		miniature := &contracts.MiniatureDto{Type: "image", Category: "test", Name: strconv.Itoa(i), Extension: "jpg"}
+		if _, err := q.GetThumbnail(ctx, miniature); !errors.Is(err, context.Canceled) {
+			t.Fatalf("request %d: expected %v, got %v", i, context.Canceled, err)
+		}
+	}
+	if pending := q.scheduler.pending(PRIORITY_INTERACTIVE); pending != 0 {
+		t.Fatalf("expected no pending thumbnails, got %d", pending)
+	}
+}
+
+// originStorage keeps an origin and its facts, other storage methods are not expected
+type originStorage struct {
+	strg.Storage
+	info *contracts.OriginInfoDto
+}
+
+func (s *originStorage) Read(origin *contracts.OriginDto) (contracts.FileDto, error) {
+	return contracts.FileDto{Contents: []byte(origin.Name)}, nil
+}
+
+func (s *originStorage) ReadOriginInfo(origin *contracts.OriginDto) (contracts.OriginInfoDto, error) {
+	if s.info == nil {
+		return contracts.OriginInfoDto{}, os.ErrNotExist
+	}
+	return *s.info, nil
+}
+
+func (s *originStorage) WriteOriginInfo(origin *contracts.OriginDto, info contracts.OriginInfoDto) error {
+	s.info = &info
+	return nil
+}
+
+// inspector counts inspections of animated origins, other thumbnailer methods are not expected
+type inspector struct {
+	thmbnlr.Thumbnailer
+	inspections int
+}
+
+func (i *inspector) Inspect(origin []byte) (thmbnlr.ImageInfo, error) {
+	i.inspections++
+	return thmbnlr.ImageInfo{Width: 10, Height: 20, Animated: true}, nil
+}
+
+func TestOriginInfoIsInspectedOnce(t *testing.T) {
+	thumbnailer := &inspector{}
+	q := &Queue{
+		storage:     &originStorage{},
+		thumbnailer: thumbnailer,
+		origins:     make(map[string]*originState),
+	}
+
+	origin := &contracts.OriginDto{Type: "image", Category: "test", Name: "animation"}
+	for range 3 {
+		info, err := q.OriginInfo(origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := contracts.OriginInfoDto{Width: 10, Height: 20, Format: "gif", Animated: true}
+		if info != expected {
+			t.Fatalf("expected %+v, got %+v", expected, info)
+		}
+	}
+	if thumbnailer.inspections != 1 {
+		t.Fatalf("expected 1 inspection, got %d", thumbnailer.inspections)
+	}
+}
@@ -0,0 +1,237 @@
+package queue
+
+import (
+	"errors"
+	"github.com/urvin/gokaru/internal/contracts"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+type Priority int
+
+const (
+	PRIORITY_INTERACTIVE Priority = iota
+	PRIORITY_WARMUP
+	PRIORITY_BATCH
+)
+
+var priorities = []Priority{PRIORITY_INTERACTIVE, PRIORITY_WARMUP, PRIORITY_BATCH}
+
+func (p Priority) String() string {
+	switch p {
+	case PRIORITY_INTERACTIVE:
+		return "interactive"
+	case PRIORITY_WARMUP:
+		return "warmup"
+	case PRIORITY_BATCH:
+		return "batch"
+	}
+	return "unknown"
+}
+
+// job is either a requested thumbnail entry or a background miniature
+type job struct {
+	priority  Priority
+	entry     *entry
+	miniature *contracts.MiniatureDto
+}
+
+func (j *job) category() string {
+	if j.entry != nil {
+		return j.entry.miniature.Category
+	}
+	return j.miniature.Category
+}
+
+// class keeps pending jobs of a priority per category, categories take turns
+type class struct {
+	reserved uint
+	limit    int
+	running  uint
+	pending  int
+	queues   map[string][]*job
+	ring     []string
+}
+
+func (c *class) push(j *job) bool {
+	if c.pending >= c.limit {
+		return false
+	}
+	category := j.category()
+	if len(c.queues[category]) == 0 {
+		c.ring = append(c.ring, category)
+	}
+	c.queues[category] = append(c.queues[category], j)
+	c.pending++
+	return true
+}
+
+func (c *class) pop() *job {
+	category := c.ring[0]
+	c.ring = c.ring[1:]
+
+	j := c.queues[category][0]
+	c.queues[category] = c.queues[category][1:]
+	if len(c.queues[category]) > 0 {
+		c.ring = append(c.ring, category)
+	} else {
+		delete(c.queues, category)
+	}
+	c.pending--
+	return j
+}
+
+// remove drops a pending job of a requested entry, false if it is not pending
+func (c *class) remove(e *entry) bool {
+	category := e.miniature.Category
+	i := slices.IndexFunc(c.queues[category], func(j *job) bool { return j.entry == e })
+	if i < 0 {
+		return false
+	}
+
+	c.queues[category] = slices.Delete(c.queues[category], i, i+1)
+	if len(c.queues[category]) == 0 {
+		delete(c.queues, category)
+		c.ring = slices.DeleteFunc(c.ring, func(name string) bool { return name == category })
+	}
+	c.pending--
+	return true
+}
+
+// scheduler hands jobs to workers by priority, workers reserved for a class are never taken by other classes
+type scheduler struct {
+	mx      sync.Mutex
+	cond    *sync.Cond
+	procs   uint
+	classes map[Priority]*class
+	closed  bool
+}
+
+func newScheduler(procs uint, limits map[Priority]int, reserved map[Priority]uint) *scheduler {
+	s := &scheduler{
+		procs:   procs,
+		classes: make(map[Priority]*class),
+	}
+	s.cond = sync.NewCond(&s.mx)
+	for _, p := range priorities {
+		s.classes[p] = &class{
+			reserved: reserved[p],
+			limit:    limits[p],
+			queues:   make(map[string][]*job),
+		}
+	}
+	return s
+}
+
+// push adds a job, false if its class is full
+func (s *scheduler) push(j *job) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.closed || !s.classes[j.priority].push(j) {
+		return false
+	}
+	s.cond.Broadcast()
+	return true
+}
+
+// next waits for a job a free worker could run, nil when the scheduler is closed and drained
+func (s *scheduler) next() *job {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for {
+		for _, p := range priorities {
+			c := s.classes[p]
+			if c.pending > 0 && s.canRun(p) {
+				c.running++
+				return c.pop()
+			}
+		}
+		if s.closed && s.classes[PRIORITY_INTERACTIVE].pending == 0 {
+			return nil
+		}
+		s.cond.Wait()
+	}
+}
+
+// close stops taking jobs and drops background ones, requested jobs are still handed out
+func (s *scheduler) close() (dropped int) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.closed = true
+	for _, p := range priorities {
+		if p == PRIORITY_INTERACTIVE {
+			continue
+		}
+		c := s.classes[p]
+		dropped += c.pending
+		c.pending = 0
+		c.queues = make(map[string][]*job)
+		c.ring = nil
+	}
+	s.cond.Broadcast()
+	return
+}
+
+// remove drops a requested entry not handed out yet, so that it does not take a place of live requests
+func (s *scheduler) remove(e *entry) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.classes[PRIORITY_INTERACTIVE].remove(e)
+}
+
+func (s *scheduler) done(j *job) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.classes[j.priority].running--
+	s.cond.Broadcast()
+}
+
+// CheckReserved makes sure every priority keeps at least one worker besides reservations of other priorities, a
+// priority could never run otherwise
+func CheckReserved(procs uint, reserved map[Priority]uint) error {
+	var total uint
+	for _, p := range priorities {
+		total += reserved[p]
+	}
+	for _, p := range priorities {
+		if total-reserved[p] >= procs {
+			return errors.New("reserved thumbnailer procs leave no worker for " + p.String() + " of " + strconv.Itoa(int(procs)))
+		}
+	}
+	return nil
+}
+
+// canRun checks free workers outnumber unused reservations of other classes
+func (s *scheduler) canRun(p Priority) bool {
+	var busy, needed uint
+	for o, c := range s.classes {
+		busy += c.running
+		if o != p && c.running < c.reserved {
+			needed += c.reserved - c.running
+		}
+	}
+	return s.procs-busy > needed
+}
+
+func (s *scheduler) pending(p Priority) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.classes[p].pending
+}
+
+func (s *scheduler) running(p Priority) uint {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.classes[p].running
+}
+
+func (s *scheduler) isClosed() bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.closed
+}
@@ -0,0 +1,126 @@
+package queue
+
+import (
+	"github.com/urvin/gokaru/internal/contracts"
+	"testing"
+)
+
+func TestCheckReserved(t *testing.T) {
+	tests := []struct {
+		name     string
+		procs    uint
+		reserved map[Priority]uint
+		valid    bool
+	}{
+		{name: "nothing reserved", procs: 4, valid: true},
+		{name: "some workers left", procs: 4, reserved: map[Priority]uint{PRIORITY_WARMUP: 3}, valid: true},
+		{name: "every priority reserved", procs: 4, reserved: map[Priority]uint{PRIORITY_INTERACTIVE: 2, PRIORITY_WARMUP: 1, PRIORITY_BATCH: 1}, valid: true},
+		{name: "reservations of others take all workers", procs: 4, reserved: map[Priority]uint{PRIORITY_WARMUP: 4}},
+		{name: "reservations of others sum to all workers", procs: 4, reserved: map[Priority]uint{PRIORITY_INTERACTIVE: 2, PRIORITY_WARMUP: 2}},
+		{name: "reservations exceed workers", procs: 2, reserved: map[Priority]uint{PRIORITY_INTERACTIVE: 3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckReserved(test.procs, test.reserved)
+			if test.valid && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func testJob(p Priority) *job {
+	return &job{priority: p, miniature: &contracts.MiniatureDto{Category: "test"}}
+}
+
+func TestSchedulerRunsEveryPriorityWithAllWorkersReserved(t *testing.T) {
+	reserved := map[Priority]uint{PRIORITY_INTERACTIVE: 2, PRIORITY_WARMUP: 1, PRIORITY_BATCH: 1}
+	if err := CheckReserved(4, reserved); err != nil {
+		t.Fatal(err)
+	}
+	s := newScheduler(4, map[Priority]int{PRIORITY_INTERACTIVE: 8, PRIORITY_WARMUP: 8, PRIORITY_BATCH: 8}, reserved)
+
+	// an idle pool runs any priority
+	for _, p := range []Priority{PRIORITY_BATCH, PRIORITY_WARMUP, PRIORITY_INTERACTIVE} {
+		s.push(testJob(p))
+		if j := s.next(); j.priority != p {
+			t.Fatalf("expected %s, got %s", p, j.priority)
+		} else {
+			s.done(j)
+		}
+	}
+
+	// background work never takes workers reserved for requests
+	for range 3 {
+		s.push(testJob(PRIORITY_BATCH))
+	}
+	j := s.next()
+	if j.priority != PRIORITY_BATCH {
+		t.Fatalf("expected batch, got %s", j.priority)
+	}
+	s.mx.Lock()
+	runnable := s.canRun(PRIORITY_BATCH)
+	s.mx.Unlock()
+	if runnable {
+		t.Fatal("batch could take workers reserved for other priorities")
+	}
+
+	s.push(testJob(PRIORITY_INTERACTIVE))
+	if j = s.next(); j.priority != PRIORITY_INTERACTIVE {
+		t.Fatalf("expected interactive, got %s", j.priority)
+	}
+}
+
+func TestSchedulerRunsUnreservedPriority(t *testing.T) {
+	reserved := map[Priority]uint{PRIORITY_WARMUP: 3}
+	if err := CheckReserved(4, reserved); err != nil {
+		t.Fatal(err)
+	}
+	s := newScheduler(4, map[Priority]int{PRIORITY_INTERACTIVE: 8, PRIORITY_WARMUP: 8, PRIORITY_BATCH: 8}, reserved)
+
+	s.push(testJob(PRIORITY_INTERACTIVE))
+	if j := s.next(); j.priority != PRIORITY_INTERACTIVE {
+		t.Fatalf("expected interactive, got %s", j.priority)
+	}
+}
+
+func TestSchedulerRemove(t *testing.T) {
+	s := newScheduler(1, map[Priority]int{PRIORITY_INTERACTIVE: 2}, nil)
+
+	first, second := testJob(PRIORITY_INTERACTIVE), testJob(PRIORITY_INTERACTIVE)
+	first.entry = newEntry(first.miniature)
+	second.entry = newEntry(second.miniature)
+	if !s.push(first) || !s.push(second) {
+		t.Fatal("jobs are not pushed")
+	}
+	if s.push(testJob(PRIORITY_INTERACTIVE)) {
+		t.Fatal("a job is pushed over the limit")
+	}
+
+	// removed jobs free their places
+	if !s.remove(first.entry) {
+		t.Fatal("pending job is not removed")
+	}
+	if s.remove(first.entry) {
+		t.Fatal("job is removed twice")
+	}
+	if pending := s.pending(PRIORITY_INTERACTIVE); pending != 1 {
+		t.Fatalf("expected 1 pending job, got %d", pending)
+	}
+	third := testJob(PRIORITY_INTERACTIVE)
+	third.entry = newEntry(third.miniature)
+	if !s.push(third) {
+		t.Fatal("job is not pushed in place of the removed one")
+	}
+
+	if j := s.next(); j != second {
+		t.Fatal("expected the second job")
+	}
+	if s.remove(second.entry) {
+		t.Fatal("running job is removed")
+	}
+}
@@ -0,0 +1,195 @@
+package sanitizer
+
+import (
+	"encoding/binary"
+)
+
+const (
+	TAG_EXIF_IFD      = 0x8769
+	TAG_GPS_IFD       = 0x8825
+	TAG_MAKER_NOTE    = 0x927C
+	TAG_UNIQUE_ID     = 0xA420
+	TAG_OWNER_NAME    = 0xA430
+	TAG_BODY_SERIAL   = 0xA431
+	TAG_LENS_SERIAL   = 0xA435
+	TAG_CAMERA_SERIAL = 0xC62F
+)
+
+// PRIVATE_TAGS are EXIF tags removed from IFD0 and the Exif IFD, by kind reported
+var PRIVATE_TAGS = map[uint16]string{
+	TAG_MAKER_NOTE:    REMOVED_MAKER_NOTE,
+	TAG_UNIQUE_ID:     REMOVED_UNIQUE_ID,
+	TAG_OWNER_NAME:    REMOVED_OWNER,
+	TAG_BODY_SERIAL:   REMOVED_SERIAL_NUMBER,
+	TAG_LENS_SERIAL:   REMOVED_SERIAL_NUMBER,
+	TAG_CAMERA_SERIAL: REMOVED_SERIAL_NUMBER,
+}
+
+// TYPE_SIZES are sizes of TIFF field types in bytes
+var TYPE_SIZES = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4,
+}
+
+const IFD_ENTRY_SIZE = 12
+
+// tiff edits EXIF data in place, removed entries are dropped from their directories and their values are zeroed so
+// that offsets of all other values are kept
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// sanitizeExif removes the GPS directory and private tags of EXIF data in place
+func sanitizeExif(data []byte, report *report) error {
+	t := &tiff{data: data}
+	switch {
+	case len(data) >= 8 && data[0] == 'I' && data[1] == 'I':
+		t.order = binary.LittleEndian
+	case len(data) >= 8 && data[0] == 'M' && data[1] == 'M':
+		t.order = binary.BigEndian
+	default:
+		return ErrMalformed
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return ErrMalformed
+	}
+
+	ifd0 := t.order.Uint32(data[4:])
+	if _, err := t.entries(ifd0); err != nil {
+		return err
+	}
+
+	if gps, ok, err := t.pointer(ifd0, TAG_GPS_IFD); err != nil {
+		return err
+	} else if ok {
+		if err = t.clear(gps); err != nil {
+			return err
+		}
+		if err = t.remove(ifd0, TAG_GPS_IFD); err != nil {
+			return err
+		}
+		report.add(REMOVED_GPS)
+	}
+
+	if err := t.removePrivate(ifd0, report); err != nil {
+		return err
+	}
+	if exif, ok, err := t.pointer(ifd0, TAG_EXIF_IFD); err != nil {
+		return err
+	} else if ok {
+		return t.removePrivate(exif, report)
+	}
+	return nil
+}
+
+// entries returns the number of entries of a directory after checking it lies within data
+func (t *tiff) entries(ifd uint32) (uint32, error) {
+	if uint64(ifd)+2 > uint64(len(t.data)) {
+		return 0, ErrMalformed
+	}
+	count := uint32(t.order.Uint16(t.data[ifd:]))
+	if uint64(ifd)+2+uint64(count)*IFD_ENTRY_SIZE+4 > uint64(len(t.data)) {
+		return 0, ErrMalformed
+	}
+	return count, nil
+}
+
+func (t *tiff) entry(ifd, index uint32) []byte {
+	offset := ifd + 2 + index*IFD_ENTRY_SIZE
+	return t.data[offset : offset+IFD_ENTRY_SIZE]
+}
+
+// find returns the index of a tag in a directory
+func (t *tiff) find(ifd uint32, tag uint16) (index uint32, ok bool, err error) {
+	count, err := t.entries(ifd)
+	if err != nil {
+		return
+	}
+	for index = 0; index < count; index++ {
+		if t.order.Uint16(t.entry(ifd, index)) == tag {
+			ok = true
+			return
+		}
+	}
+	return
+}
+
+// pointer returns the offset of a sub-directory pointed by a tag of a directory
+func (t *tiff) pointer(ifd uint32, tag uint16) (offset uint32, ok bool, err error) {
+	index, ok, err := t.find(ifd, tag)
+	if err != nil || !ok {
+		return
+	}
+	offset = t.order.Uint32(t.entry(ifd, index)[8:])
+	_, err = t.entries(offset)
+	return
+}
+
+// zeroValue zeroes the value of an entry stored out of the entry
+func (t *tiff) zeroValue(entry []byte) error {
+	size, known := TYPE_SIZES[t.order.Uint16(entry[2:])]
+	length := uint64(size) * uint64(t.order.Uint32(entry[4:]))
+	if !known || length <= 4 {
+		return nil
+	}
+
+	offset := uint64(t.order.Uint32(entry[8:]))
+	if offset+length > uint64(len(t.data)) {
+		return ErrMalformed
+	}
+	clear(t.data[offset : offset+length])
+	return nil
+}
+
+// clear zeroes a directory with its values, directories it points to are not followed
+func (t *tiff) clear(ifd uint32) error {
+	count, err := t.entries(ifd)
+	if err != nil {
+		return err
+	}
+	for index := uint32(0); index < count; index++ {
+		if err = t.zeroValue(t.entry(ifd, index)); err != nil {
+			return err
+		}
+	}
+	clear(t.data[ifd : ifd+2+count*IFD_ENTRY_SIZE+4])
+	return nil
+}
+
+// remove drops a tag of a directory and zeroes its value, the following entries and the next directory offset are
+// moved up
+func (t *tiff) remove(ifd uint32, tag uint16) error {
+	index, ok, err := t.find(ifd, tag)
+	if err != nil || !ok {
+		return err
+	}
+	if err = t.zeroValue(t.entry(ifd, index)); err != nil {
+		return err
+	}
+
+	count, _ := t.entries(ifd)
+	start := ifd + 2 + index*IFD_ENTRY_SIZE
+	end := ifd + 2 + count*IFD_ENTRY_SIZE + 4
+	copy(t.data[start:], t.data[start+IFD_ENTRY_SIZE:end])
+	clear(t.data[end-IFD_ENTRY_SIZE : end])
+	t.order.PutUint16(t.data[ifd:], uint16(count-1))
+	return nil
+}
+
+// removePrivate drops private tags of a directory
+func (t *tiff) removePrivate(ifd uint32, report *report) error {
+	for tag, kind := range PRIVATE_TAGS {
+		_, ok, err := t.find(ifd, tag)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err = t.remove(ifd, tag); err != nil {
+			return err
+		}
+		report.add(kind)
+	}
+	return nil
+}
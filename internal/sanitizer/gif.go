@@ -0,0 +1,101 @@
+package sanitizer
+
+import (
+	"bytes"
+)
+
+var (
+	GIF87_SIGNATURE = []byte("GIF87a")
+	GIF89_SIGNATURE = []byte("GIF89a")
+)
+
+const (
+	GIF_EXTENSION       = 0x21
+	GIF_IMAGE           = 0x2C
+	GIF_TRAILER         = 0x3B
+	GIF_LABEL_APP       = 0xFF
+	GIF_FLAG_COLORTABLE = 0x80
+)
+
+// GIF_XMP_APP is the identifier and authentication code of application extensions holding XMP packets
+var GIF_XMP_APP = []byte("\x0BXMP DataXMP")
+
+// sanitizeGif rewrites blocks, XMP application extensions are dropped. GIF has no EXIF.
+func sanitizeGif(data []byte, report *report) ([]byte, error) {
+	// header and logical screen descriptor
+	position := 13
+	if len(data) < position {
+		return nil, ErrMalformed
+	}
+	position += gifColorTable(data[10])
+	if position > len(data) {
+		return nil, ErrMalformed
+	}
+
+	result := bytes.NewBuffer(make([]byte, 0, len(data)))
+	result.Write(data[:position])
+
+	for {
+		if position >= len(data) {
+			return nil, ErrMalformed
+		}
+
+		start := position
+		switch data[position] {
+		case GIF_TRAILER:
+			// trailing data is kept as is
+			result.Write(data[position:])
+			return result.Bytes(), nil
+		case GIF_EXTENSION:
+			if position+2 > len(data) {
+				return nil, ErrMalformed
+			}
+			label := data[position+1]
+			end, err := gifSubBlocks(data, position+2)
+			if err != nil {
+				return nil, err
+			}
+			position = end
+			if label == GIF_LABEL_APP && bytes.HasPrefix(data[start+2:], GIF_XMP_APP) {
+				report.add(REMOVED_XMP)
+				continue
+			}
+		case GIF_IMAGE:
+			// image descriptor, local colour table and LZW minimum code size
+			if position+10 > len(data) {
+				return nil, ErrMalformed
+			}
+			position += 10 + gifColorTable(data[position+9]) + 1
+			end, err := gifSubBlocks(data, position)
+			if err != nil {
+				return nil, err
+			}
+			position = end
+		default:
+			return nil, ErrMalformed
+		}
+		result.Write(data[start:position])
+	}
+}
+
+// gifColorTable returns the size of a colour table by packed fields of its descriptor
+func gifColorTable(packed byte) int {
+	if packed&GIF_FLAG_COLORTABLE == 0 {
+		return 0
+	}
+	return 3 << (packed&0x07 + 1)
+}
+
+// gifSubBlocks returns the position after data sub-blocks and their terminator
+func gifSubBlocks(data []byte, position int) (int, error) {
+	for {
+		if position >= len(data) {
+			return 0, ErrMalformed
+		}
+		size := int(data[position])
+		position += 1 + size
+		if size == 0 {
+			return position, nil
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package sanitizer
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var JPEG_SIGNATURE = []byte{0xFF, 0xD8}
+
+const (
+	JPEG_MARKER_APP1 = 0xE1
+	JPEG_MARKER_SOS  = 0xDA
+	JPEG_MARKER_EOI  = 0xD9
+	JPEG_MARKER_TEM  = 0x01
+	JPEG_MARKER_RST0 = 0xD0
+	JPEG_MARKER_RST7 = 0xD7
+)
+
+var (
+	JPEG_EXIF_HEADER          = []byte("Exif\x00\x00")
+	JPEG_XMP_HEADER           = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	JPEG_XMP_EXTENSION_HEADER = []byte("http://ns.adobe.com/xmp/extension/\x00")
+)
+
+// sanitizeJpeg rewrites segments before the scan data, EXIF segments are edited and XMP ones are dropped, the rest
+// of the file is copied as is
+func sanitizeJpeg(data []byte, report *report) ([]byte, error) {
+	result := bytes.NewBuffer(make([]byte, 0, len(data)))
+	result.Write(JPEG_SIGNATURE)
+
+	position := len(JPEG_SIGNATURE)
+	for {
+		if position+2 > len(data) || data[position] != 0xFF {
+			return nil, ErrMalformed
+		}
+		// markers could be preceded by fill bytes
+		if data[position+1] == 0xFF {
+			position++
+			continue
+		}
+
+		marker := data[position+1]
+		if marker == JPEG_MARKER_SOS || marker == JPEG_MARKER_EOI {
+			result.Write(data[position:])
+			return result.Bytes(), nil
+		}
+		if marker == JPEG_MARKER_TEM || marker >= JPEG_MARKER_RST0 && marker <= JPEG_MARKER_RST7 {
+			result.Write(data[position : position+2])
+			position += 2
+			continue
+		}
+
+		if position+4 > len(data) {
+			return nil, ErrMalformed
+		}
+		end := position + 2 + int(binary.BigEndian.Uint16(data[position+2:]))
+		if end > len(data) || end < position+4 {
+			return nil, ErrMalformed
+		}
+		segment := data[position:end]
+		position = end
+
+		if marker != JPEG_MARKER_APP1 {
+			result.Write(segment)
+			continue
+		}
+
+		payload := segment[4:]
+		switch {
+		case bytes.HasPrefix(payload, JPEG_XMP_HEADER) || bytes.HasPrefix(payload, JPEG_XMP_EXTENSION_HEADER):
+			report.add(REMOVED_XMP)
+		case bytes.HasPrefix(payload, JPEG_EXIF_HEADER):
+			segment = bytes.Clone(segment)
+			if err := sanitizeExif(segment[4+len(JPEG_EXIF_HEADER):], report); err != nil {
+				return nil, err
+			}
+			result.Write(segment)
+		default:
+			result.Write(segment)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package sanitizer
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var JXL_SIGNATURE = []byte("\x00\x00\x00\x0CJXL \x0D\x0A\x87\x0A")
+
+const (
+	JXL_BOX_EXIF   = "Exif"
+	JXL_BOX_XMP    = "xml "
+	JXL_BOX_BROTLI = "brob"
+	// whole and partial codestream boxes
+	JXL_BOX_CODESTREAM = "jxlc"
+	JXL_BOX_PARTIAL    = "jxlp"
+)
+
+// sanitizeJxl rewrites boxes of the JPEG XL container, EXIF boxes are edited and XMP ones are dropped. Brotli
+// compressed EXIF boxes could not be edited and are dropped too. Bare codestreams have no metadata and are not passed
+// here.
+func sanitizeJxl(data []byte, report *report) ([]byte, error) {
+	result := bytes.NewBuffer(make([]byte, 0, len(data)))
+
+	codestream := false
+	position := 0
+	for position < len(data) {
+		if position+8 > len(data) {
+			return nil, ErrMalformed
+		}
+		header := 8
+		length := uint64(binary.BigEndian.Uint32(data[position:]))
+		switch length {
+		case 0:
+			// the last box extends to the end of the file
+			length = uint64(len(data) - position)
+		case 1:
+			if position+16 > len(data) {
+				return nil, ErrMalformed
+			}
+			header = 16
+			length = binary.BigEndian.Uint64(data[position+8:])
+		}
+		if length < uint64(header) || uint64(position)+length > uint64(len(data)) {
+			return nil, ErrMalformed
+		}
+		box := data[position : position+int(length)]
+		position += int(length)
+
+		kind := string(box[4:8])
+		if kind == JXL_BOX_BROTLI {
+			if len(box) < header+4 {
+				return nil, ErrMalformed
+			}
+			kind = string(box[header : header+4])
+			switch kind {
+			case JXL_BOX_XMP:
+				report.add(REMOVED_XMP)
+				continue
+			case JXL_BOX_EXIF:
+				report.add(REMOVED_EXIF)
+				continue
+			}
+			result.Write(box)
+			continue
+		}
+
+		switch kind {
+		case JXL_BOX_CODESTREAM, JXL_BOX_PARTIAL:
+			codestream = true
+			result.Write(box)
+		case JXL_BOX_XMP:
+			report.add(REMOVED_XMP)
+		case JXL_BOX_EXIF:
+			// the payload starts with the offset of the TIFF header
+			if len(box) < header+4 {
+				return nil, ErrMalformed
+			}
+			offset := uint64(binary.BigEndian.Uint32(box[header:]))
+			if uint64(header)+4+offset > uint64(len(box)) {
+				return nil, ErrMalformed
+			}
+			box = bytes.Clone(box)
+			if err := sanitizeExif(box[header+4+int(offset):], report); err != nil {
+				return nil, err
+			}
+			result.Write(box)
+		default:
+			result.Write(box)
+		}
+	}
+
+	// truncated before the codestream
+	if !codestream {
+		return nil, ErrMalformed
+	}
+	return result.Bytes(), nil
+}
@@ -0,0 +1,228 @@
+package sanitizer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var PNG_SIGNATURE = []byte("\x89PNG\r\n\x1a\n")
+
+const (
+	PNG_CHUNK_EXIF = "eXIf"
+	PNG_CHUNK_TEXT = "tEXt"
+	PNG_CHUNK_ZTXT = "zTXt"
+	PNG_CHUNK_ITXT = "iTXt"
+	PNG_CHUNK_IEND = "IEND"
+)
+
+// PNG_XMP_KEYWORD is the keyword of iTXt chunks holding XMP packets
+var PNG_XMP_KEYWORD = []byte("XML:com.adobe.xmp\x00")
+
+// lower case keywords of text chunks holding hex encoded raw profiles of ImageMagick and exiftool
+var PNG_RAW_EXIF_KEYWORDS = []string{"raw profile type exif", "raw profile type app1"}
+
+const PNG_RAW_XMP_KEYWORD = "raw profile type xmp"
+
+// PNG_TEXT_MAX limits the size of decompressed text of a raw profile
+const PNG_TEXT_MAX = 16 << 20
+
+// sanitizePng rewrites chunks, EXIF chunks and EXIF raw profiles of text chunks are edited and XMP ones are dropped
+func sanitizePng(data []byte, report *report) ([]byte, error) {
+	result := bytes.NewBuffer(make([]byte, 0, len(data)))
+	result.Write(PNG_SIGNATURE)
+
+	position := len(PNG_SIGNATURE)
+	for position < len(data) {
+		if position+12 > len(data) {
+			return nil, ErrMalformed
+		}
+		length := uint64(binary.BigEndian.Uint32(data[position:]))
+		if uint64(position)+12+length > uint64(len(data)) {
+			return nil, ErrMalformed
+		}
+		end := position + 12 + int(length)
+		chunk := data[position:end]
+		position = end
+
+		kind := string(chunk[4:8])
+		switch {
+		case kind == PNG_CHUNK_ITXT && bytes.HasPrefix(chunk[8:], PNG_XMP_KEYWORD):
+			report.add(REMOVED_XMP)
+		case kind == PNG_CHUNK_TEXT || kind == PNG_CHUNK_ZTXT || kind == PNG_CHUNK_ITXT:
+			edited, err := sanitizePngText(chunk, report)
+			if err != nil {
+				return nil, err
+			}
+			result.Write(edited)
+		case kind == PNG_CHUNK_EXIF:
+			chunk = bytes.Clone(chunk)
+			if err := sanitizeExif(chunk[8:len(chunk)-4], report); err != nil {
+				return nil, err
+			}
+			binary.BigEndian.PutUint32(chunk[len(chunk)-4:], crc32.ChecksumIEEE(chunk[4:len(chunk)-4]))
+			result.Write(chunk)
+		default:
+			result.Write(chunk)
+		}
+
+		if kind == PNG_CHUNK_IEND {
+			// trailing data is kept as is
+			result.Write(data[position:])
+			return result.Bytes(), nil
+		}
+	}
+	// truncated before the end chunk
+	return nil, ErrMalformed
+}
+
+// sanitizePngText edits EXIF of a raw profile text chunk and drops XMP one, other text chunks are returned as is
+func sanitizePngText(chunk []byte, report *report) ([]byte, error) {
+	kind := string(chunk[4:8])
+	payload := chunk[8 : len(chunk)-4]
+	keyword, rest, found := bytes.Cut(payload, []byte{0})
+	if !found {
+		return chunk, nil
+	}
+	switch name := strings.ToLower(string(keyword)); {
+	case name == PNG_RAW_XMP_KEYWORD:
+		report.add(REMOVED_XMP)
+		return nil, nil
+	case !slices.Contains(PNG_RAW_EXIF_KEYWORDS, name):
+		return chunk, nil
+	}
+
+	// text follows the compression method of zTXt and the compression flag, method, language and translated keyword
+	// of iTXt
+	compressed := false
+	switch kind {
+	case PNG_CHUNK_ZTXT:
+		if len(rest) < 1 {
+			return nil, ErrMalformed
+		}
+		compressed = true
+		rest = rest[1:]
+	case PNG_CHUNK_ITXT:
+		if len(rest) < 2 {
+			return nil, ErrMalformed
+		}
+		compressed = rest[0] != 0
+		rest = rest[2:]
+		for i := 0; i < 2; i++ {
+			if _, rest, found = bytes.Cut(rest, []byte{0}); !found {
+				return nil, ErrMalformed
+			}
+		}
+	}
+	header := payload[:len(payload)-len(rest)]
+
+	text := bytes.Clone(rest)
+	if compressed {
+		var err error
+		if text, err = inflate(rest); err != nil {
+			return nil, err
+		}
+	}
+
+	profile, digits, err := rawProfile(text)
+	if err != nil {
+		return nil, err
+	}
+	exif := bytes.Clone(profile)
+	if err = sanitizeExif(bytes.TrimPrefix(exif, JPEG_EXIF_HEADER), report); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(exif, profile) {
+		return chunk, nil
+	}
+
+	// changed bytes are written over their digits keeping the layout of the text
+	for i := range exif {
+		if exif[i] != profile[i] {
+			text[digits[2*i]] = HEX_DIGITS[exif[i]>>4]
+			text[digits[2*i+1]] = HEX_DIGITS[exif[i]&0x0F]
+		}
+	}
+	if compressed {
+		var buffer bytes.Buffer
+		writer := zlib.NewWriter(&buffer)
+		_, _ = writer.Write(text)
+		_ = writer.Close()
+		text = buffer.Bytes()
+	}
+
+	edited := binary.BigEndian.AppendUint32(nil, uint32(len(header)+len(text)))
+	edited = append(edited, kind...)
+	edited = append(edited, header...)
+	edited = append(edited, text...)
+	edited = binary.BigEndian.AppendUint32(edited, crc32.ChecksumIEEE(edited[4:]))
+	return edited, nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	inflated, err := io.ReadAll(io.LimitReader(reader, PNG_TEXT_MAX+1))
+	if err != nil || len(inflated) > PNG_TEXT_MAX {
+		return nil, ErrMalformed
+	}
+	return inflated, nil
+}
+
+const (
+	HEX_DIGITS       = "0123456789abcdef"
+	HEX_DIGITS_UPPER = "0123456789ABCDEF"
+)
+
+// rawProfile decodes a raw profile, "\n<name>\n<length>\n<hex digits split by new lines>", digits are positions of
+// hex digits of the profile in the text
+func rawProfile(text []byte) (profile []byte, digits []int, err error) {
+	position := 0
+	skip := func(space bool) {
+		for position < len(text) && isSpace(text[position]) == space {
+			position++
+		}
+	}
+	// name and length
+	skip(true)
+	skip(false)
+	skip(true)
+	start := position
+	skip(false)
+	length, err := strconv.Atoi(string(text[start:position]))
+	if err != nil || length < 0 || length > len(text)/2 {
+		return nil, nil, ErrMalformed
+	}
+
+	profile = make([]byte, length)
+	digits = make([]int, 0, 2*length)
+	for ; position < len(text) && len(digits) < 2*length; position++ {
+		if isSpace(text[position]) {
+			continue
+		}
+		value := strings.IndexByte(HEX_DIGITS, text[position])
+		if value < 0 {
+			value = strings.IndexByte(HEX_DIGITS_UPPER, text[position])
+		}
+		if value < 0 {
+			return nil, nil, ErrMalformed
+		}
+		profile[len(digits)/2] = profile[len(digits)/2]<<4 | byte(value)
+		digits = append(digits, position)
+	}
+	if len(digits) < 2*length {
+		return nil, nil, ErrMalformed
+	}
+	return profile, digits, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,61 @@
+package sanitizer
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+)
+
+// kinds of metadata removed from origins
+const REMOVED_GPS = "gps"
+const REMOVED_SERIAL_NUMBER = "serial_number"
+const REMOVED_OWNER = "owner"
+const REMOVED_UNIQUE_ID = "unique_id"
+const REMOVED_MAKER_NOTE = "maker_note"
+const REMOVED_XMP = "xmp"
+
+// REMOVED_EXIF is reported for EXIF removed entirely as it could not be edited
+const REMOVED_EXIF = "exif"
+
+var ErrMalformed = errors.New("malformed image metadata")
+
+// Sanitize removes location, camera serial numbers, owner and XMP metadata of JPEG, PNG, WebP, JPEG XL and GIF images
+// without re-encoding them, other data, e.g. BMP without metadata, is returned as is. Removed is the sorted list of
+// kinds of removed metadata, data is returned unchanged if there are none.
+func Sanitize(data []byte) (result []byte, removed []string, err error) {
+	report := &report{}
+	switch {
+	case bytes.HasPrefix(data, JPEG_SIGNATURE):
+		result, err = sanitizeJpeg(data, report)
+	case bytes.HasPrefix(data, PNG_SIGNATURE):
+		result, err = sanitizePng(data, report)
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		result, err = sanitizeWebp(data, report)
+	case bytes.HasPrefix(data, JXL_SIGNATURE):
+		result, err = sanitizeJxl(data, report)
+	case bytes.HasPrefix(data, GIF87_SIGNATURE) || bytes.HasPrefix(data, GIF89_SIGNATURE):
+		result, err = sanitizeGif(data, report)
+	default:
+		return data, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(report.removed) == 0 {
+		return data, nil, nil
+	}
+
+	removed = report.removed
+	slices.Sort(removed)
+	return
+}
+
+type report struct {
+	removed []string
+}
+
+func (r *report) add(kind string) {
+	if !slices.Contains(r.removed, kind) {
+		r.removed = append(r.removed, kind)
+	}
+}
@@ -0,0 +1,669 @@
+package sanitizer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// offsets of the EXIF fixture, directories are followed by values
+const (
+	FIXTURE_EXIF_IFD      = 62
+	FIXTURE_GPS_IFD       = 104
+	FIXTURE_ARTIST        = 134
+	FIXTURE_CAMERA_SERIAL = 142
+	FIXTURE_EXPOSURE      = 150
+	FIXTURE_LENS_SERIAL   = 158
+	FIXTURE_LATITUDE      = 166
+	FIXTURE_SIZE          = 190
+)
+
+var FIXTURE_XMP = []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><exif:GPSLatitude>55,45N</exif:GPSLatitude></x:xmpmeta>`)
+
+// removed tags of the EXIF fixture by directory and tag
+var FIXTURE_REMOVED = []string{"0/8825", "0/c62f", "exif/a431", "exif/a435", "gps/0001", "gps/0002"}
+
+// exifFixture builds EXIF data with an artist and an exposure time to keep, camera, body and lens serial numbers and
+// a GPS directory to remove
+func exifFixture(order binary.ByteOrder) []byte {
+	data := make([]byte, FIXTURE_SIZE)
+	if order == binary.LittleEndian {
+		copy(data, "II")
+	} else {
+		copy(data, "MM")
+	}
+	order.PutUint16(data[2:], 42)
+	order.PutUint32(data[4:], 8)
+
+	directory := func(offset int, entries ...[4]uint32) {
+		order.PutUint16(data[offset:], uint16(len(entries)))
+		for i, entry := range entries {
+			at := offset + 2 + i*IFD_ENTRY_SIZE
+			order.PutUint16(data[at:], uint16(entry[0]))
+			order.PutUint16(data[at+2:], uint16(entry[1]))
+			order.PutUint32(data[at+4:], entry[2])
+			order.PutUint32(data[at+8:], entry[3])
+		}
+	}
+	directory(8,
+		[4]uint32{0x013B, 2, 8, FIXTURE_ARTIST},
+		[4]uint32{TAG_EXIF_IFD, 4, 1, FIXTURE_EXIF_IFD},
+		[4]uint32{TAG_GPS_IFD, 4, 1, FIXTURE_GPS_IFD},
+		[4]uint32{TAG_CAMERA_SERIAL, 2, 8, FIXTURE_CAMERA_SERIAL},
+	)
+	directory(FIXTURE_EXIF_IFD,
+		[4]uint32{0x829A, 5, 1, FIXTURE_EXPOSURE},
+		[4]uint32{TAG_BODY_SERIAL, 2, 4, 0},
+		[4]uint32{TAG_LENS_SERIAL, 2, 8, FIXTURE_LENS_SERIAL},
+	)
+	// the inline body serial number
+	copy(data[FIXTURE_EXIF_IFD+2+IFD_ENTRY_SIZE+8:], "B01\x00")
+	directory(FIXTURE_GPS_IFD,
+		[4]uint32{1, 2, 2, 0},
+		[4]uint32{2, 5, 3, FIXTURE_LATITUDE},
+	)
+	copy(data[FIXTURE_GPS_IFD+2+8:], "N\x00")
+
+	copy(data[FIXTURE_ARTIST:], "ARTIST!\x00")
+	copy(data[FIXTURE_CAMERA_SERIAL:], "CAM0001\x00")
+	order.PutUint32(data[FIXTURE_EXPOSURE:], 1)
+	order.PutUint32(data[FIXTURE_EXPOSURE+4:], 250)
+	copy(data[FIXTURE_LENS_SERIAL:], "LENS001\x00")
+	for i, value := range []uint32{55, 1, 45, 1, 30, 1} {
+		order.PutUint32(data[FIXTURE_LATITUDE+4*i:], value)
+	}
+	return data
+}
+
+// readTags returns values of tags of IFD0 and the Exif and GPS directories by directory and tag
+func readTags(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+
+	order := binary.ByteOrder(binary.BigEndian)
+	if data[0] == 'I' {
+		order = binary.LittleEndian
+	}
+	tags := make(map[string][]byte)
+	var read func(name string, offset uint32)
+	read = func(name string, offset uint32) {
+		count := uint32(order.Uint16(data[offset:]))
+		for i := uint32(0); i < count; i++ {
+			entry := data[offset+2+i*IFD_ENTRY_SIZE:][:IFD_ENTRY_SIZE]
+			tag := order.Uint16(entry)
+			value := entry[8:12]
+			if length := TYPE_SIZES[order.Uint16(entry[2:])] * order.Uint32(entry[4:]); length > 4 {
+				value = data[order.Uint32(value):][:length]
+			}
+			tags[fmt.Sprintf("%s/%04x", name, tag)] = value
+
+			switch {
+			case name == "0" && tag == TAG_EXIF_IFD:
+				read("exif", order.Uint32(value))
+			case name == "0" && tag == TAG_GPS_IFD:
+				read("gps", order.Uint32(value))
+			}
+		}
+	}
+	read("0", order.Uint32(data[4:]))
+	return tags
+}
+
+// fixture is an image with the EXIF fixture and XMP
+type fixture struct {
+	data []byte
+	// pixels are bytes of image data expected in the sanitized image as is
+	pixels []byte
+	// complete is the length truncated images are accepted from, the whole image by default
+	complete int
+	// exif extracts EXIF data of a sanitized image, the first TIFF header by default
+	exif func(t *testing.T, data []byte) []byte
+}
+
+func jpegFixture(t *testing.T, exif []byte) fixture {
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buffer.Bytes()
+
+	app1 := func(payload ...[]byte) []byte {
+		joined := bytes.Join(payload, nil)
+		return slices.Concat([]byte{0xFF, JPEG_MARKER_APP1}, binary.BigEndian.AppendUint16(nil, uint16(len(joined)+2)), joined)
+	}
+
+	data := slices.Concat(JPEG_SIGNATURE, app1(JPEG_EXIF_HEADER, exif), app1(JPEG_XMP_HEADER, FIXTURE_XMP), encoded[2:])
+	scan := encoded[bytes.Index(encoded, []byte{0xFF, JPEG_MARKER_SOS}):]
+	return fixture{
+		data:     data,
+		pixels:   scan,
+		complete: len(data) - len(scan) + 2,
+	}
+}
+
+func pngChunk(kind string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// pngFixture inserts metadata chunks after the header chunk of an encoded image
+func pngFixture(t *testing.T, metadata ...[]byte) fixture {
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buffer.Bytes()
+	header := len(PNG_SIGNATURE) + 25
+
+	data := slices.Concat(encoded[:header], bytes.Join(metadata, nil), encoded[header:])
+	start := bytes.Index(encoded, []byte("IDAT")) - 4
+	end := bytes.Index(encoded, []byte(PNG_CHUNK_IEND)) - 4
+	return fixture{
+		data:   data,
+		pixels: encoded[start:end],
+	}
+}
+
+func pngExifFixture(t *testing.T, exif []byte) fixture {
+	return pngFixture(t, pngChunk(PNG_CHUNK_EXIF, exif), pngChunk(PNG_CHUNK_ITXT, slices.Concat(PNG_XMP_KEYWORD, []byte{0, 0, 0, 0}, FIXTURE_XMP)))
+}
+
+// rawProfileText encodes a profile the way ImageMagick does
+func rawProfileText(name string, profile []byte) []byte {
+	encoded := hex.EncodeToString(profile)
+	text := fmt.Sprintf("\n%s\n%8d", name, len(profile))
+	for len(encoded) > 0 {
+		line := encoded[:min(72, len(encoded))]
+		encoded = encoded[len(line):]
+		text += "\n" + line
+	}
+	return []byte(text + "\n")
+}
+
+func deflate(data []byte) []byte {
+	var buffer bytes.Buffer
+	writer := zlib.NewWriter(&buffer)
+	_, _ = writer.Write(data)
+	_ = writer.Close()
+	return buffer.Bytes()
+}
+
+func pngRawProfileFixture(t *testing.T, exif []byte, kind string) fixture {
+	text := rawProfileText("exif", slices.Concat(JPEG_EXIF_HEADER, exif))
+	xmp := slices.Concat([]byte("Raw profile type xmp\x00"), rawProfileText("xmp", FIXTURE_XMP))
+
+	var chunk []byte
+	switch kind {
+	case PNG_CHUNK_TEXT:
+		chunk = pngChunk(kind, slices.Concat([]byte("Raw profile type exif\x00"), text))
+	case PNG_CHUNK_ZTXT:
+		chunk = pngChunk(kind, slices.Concat([]byte("Raw profile type exif\x00\x00"), deflate(text)))
+	case PNG_CHUNK_ITXT:
+		chunk = pngChunk(kind, slices.Concat([]byte("Raw profile type APP1\x00\x01\x00\x00\x00"), deflate(text)))
+	}
+
+	result := pngFixture(t, chunk, pngChunk(PNG_CHUNK_TEXT, xmp))
+	result.exif = rawProfileExif
+	return result
+}
+
+// rawProfileExif decodes EXIF of the first raw profile of a sanitized image
+func rawProfileExif(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	for position := len(PNG_SIGNATURE); position+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[position:]))
+		kind := string(data[position+4 : position+8])
+		payload := data[position+8 : position+8+length]
+		position += 12 + length
+
+		keyword, rest, _ := bytes.Cut(payload, []byte{0})
+		if !strings.HasPrefix(strings.ToLower(string(keyword)), "raw profile type") {
+			continue
+		}
+		text := rest
+		switch kind {
+		case PNG_CHUNK_ZTXT:
+			text, _ = inflate(rest[1:])
+		case PNG_CHUNK_ITXT:
+			text, _ = inflate(rest[4:])
+		}
+		profile, _, err := rawProfile(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bytes.TrimPrefix(profile, JPEG_EXIF_HEADER)
+	}
+	t.Fatal("no raw profile")
+	return nil
+}
+
+func webpChunk(kind string, payload []byte) []byte {
+	chunk := binary.LittleEndian.AppendUint32([]byte(kind), uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpFixture(t *testing.T, exif []byte) fixture {
+	// bitstreams are not decoded, any bytes do
+	pixels := webpChunk("VP8L", []byte("\x2f\x03\xc0\x00pixel data"))
+	chunks := slices.Concat(
+		webpChunk(WEBP_CHUNK_VP8X, []byte{0x08 | WEBP_FLAG_XMP, 0, 0, 0, 3, 0, 0, 3, 0, 0}),
+		pixels,
+		webpChunk(WEBP_CHUNK_EXIF, exif),
+		webpChunk(WEBP_CHUNK_XMP, FIXTURE_XMP),
+	)
+	data := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(chunks)+4))
+	data = slices.Concat(data, []byte("WEBP"), chunks)
+	return fixture{
+		data:   data,
+		pixels: pixels,
+		// the padding byte of the last chunk could be missing
+		complete: len(data) - len(FIXTURE_XMP)%2,
+	}
+}
+
+func jxlBox(kind string, payload []byte) []byte {
+	box := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+8))
+	return append(append(box, kind...), payload...)
+}
+
+func jxlFixture(t *testing.T, exif []byte) fixture {
+	// the codestream is not decoded, any bytes do
+	pixels := jxlBox(JXL_BOX_CODESTREAM, []byte("\xff\x0acodestream"))
+	return fixture{
+		data: slices.Concat(
+			JXL_SIGNATURE,
+			jxlBox("ftyp", []byte("jxl \x00\x00\x00\x00jxl ")),
+			jxlBox(JXL_BOX_EXIF, slices.Concat([]byte{0, 0, 0, 6}, JPEG_EXIF_HEADER, exif)),
+			jxlBox(JXL_BOX_XMP, FIXTURE_XMP),
+			pixels,
+		),
+		pixels: pixels,
+	}
+}
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+	}
+	return img
+}
+
+var fixtures = []struct {
+	name  string
+	build func(t *testing.T, exif []byte) fixture
+}{
+	{name: "jpeg", build: jpegFixture},
+	{name: "png eXIf", build: pngExifFixture},
+	{name: "png tEXt raw profile", build: func(t *testing.T, exif []byte) fixture {
+		return pngRawProfileFixture(t, exif, PNG_CHUNK_TEXT)
+	}},
+	{name: "png zTXt raw profile", build: func(t *testing.T, exif []byte) fixture {
+		return pngRawProfileFixture(t, exif, PNG_CHUNK_ZTXT)
+	}},
+	{name: "png iTXt raw profile", build: func(t *testing.T, exif []byte) fixture {
+		return pngRawProfileFixture(t, exif, PNG_CHUNK_ITXT)
+	}},
+	{name: "webp", build: webpFixture},
+	{name: "jxl", build: jxlFixture},
+}
+
+var orders = []struct {
+	name  string
+	order binary.ByteOrder
+}{
+	{name: "little endian", order: binary.LittleEndian},
+	{name: "big endian", order: binary.BigEndian},
+}
+
+func TestSanitize(t *testing.T) {
+	for _, test := range fixtures {
+		for _, order := range orders {
+			t.Run(test.name+" "+order.name, func(t *testing.T) {
+				exif := exifFixture(order.order)
+				f := test.build(t, exif)
+
+				sanitized, removed, err := Sanitize(f.data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if expected := []string{REMOVED_GPS, REMOVED_SERIAL_NUMBER, REMOVED_XMP}; !slices.Equal(removed, expected) {
+					t.Fatalf("expected removed %v, got %v", expected, removed)
+				}
+				if !bytes.Contains(sanitized, f.pixels) {
+					t.Fatal("image data is changed")
+				}
+				if bytes.Contains(sanitized, []byte("GPSLatitude")) || bytes.Contains(sanitized, []byte(hex.EncodeToString([]byte("GPSLatitude")))) {
+					t.Fatal("XMP is left")
+				}
+
+				var result []byte
+				if f.exif != nil {
+					result = f.exif(t, sanitized)
+				} else {
+					result = sanitized[bytes.Index(sanitized, exif[:4]):][:FIXTURE_SIZE]
+				}
+
+				expected := readTags(t, exif)
+				for _, tag := range FIXTURE_REMOVED {
+					delete(expected, tag)
+				}
+				tags := readTags(t, result)
+				if len(tags) != len(expected) {
+					t.Fatalf("expected tags %v, got %v", expected, tags)
+				}
+				for tag, value := range expected {
+					if !bytes.Equal(tags[tag], value) {
+						t.Fatalf("tag %s is changed from %v to %v", tag, value, tags[tag])
+					}
+				}
+
+				// values of removed tags are zeroed
+				for _, area := range [][2]int{
+					{FIXTURE_GPS_IFD, FIXTURE_ARTIST},
+					{FIXTURE_CAMERA_SERIAL, FIXTURE_EXPOSURE},
+					{FIXTURE_LENS_SERIAL, FIXTURE_SIZE},
+				} {
+					if slices.ContainsFunc(result[area[0]:area[1]], func(b byte) bool { return b != 0 }) {
+						t.Fatalf("bytes %d to %d are not zeroed", area[0], area[1])
+					}
+				}
+				if bytes.Contains(result, []byte("B01")) {
+					t.Fatal("body serial number is left")
+				}
+
+				again, removed, err := Sanitize(sanitized)
+				if err != nil || removed != nil || !bytes.Equal(again, sanitized) {
+					t.Fatalf("sanitized image is changed again, removed %v, error %v", removed, err)
+				}
+			})
+		}
+	}
+}
+
+func TestSanitizeDecodable(t *testing.T) {
+	exif := exifFixture(binary.BigEndian)
+	decoders := map[string]func(data []byte) (image.Image, error){
+		"jpeg":                 func(data []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(data)) },
+		"png eXIf":             func(data []byte) (image.Image, error) { return png.Decode(bytes.NewReader(data)) },
+		"png zTXt raw profile": func(data []byte) (image.Image, error) { return png.Decode(bytes.NewReader(data)) },
+	}
+	for _, test := range fixtures {
+		decode, ok := decoders[test.name]
+		if !ok {
+			continue
+		}
+		t.Run(test.name, func(t *testing.T) {
+			f := test.build(t, exif)
+			sanitized, _, err := Sanitize(f.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			original, err := decode(f.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := decode(sanitized)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bounds := original.Bounds()
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					if original.At(x, y) != result.At(x, y) {
+						t.Fatalf("pixel %d,%d is changed", x, y)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeWebpFlags(t *testing.T) {
+	f := webpFixture(t, exifFixture(binary.LittleEndian))
+	sanitized, _, err := Sanitize(f.data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags := sanitized[20]; flags != 0x08 {
+		t.Fatalf("expected VP8X flags of EXIF only, got %#x", flags)
+	}
+	if size := binary.LittleEndian.Uint32(sanitized[4:]); int(size) != len(sanitized)-8 {
+		t.Fatalf("RIFF size %d does not match %d", size, len(sanitized)-8)
+	}
+}
+
+func TestSanitizeJxlCompressedBoxes(t *testing.T) {
+	codestream := jxlBox(JXL_BOX_CODESTREAM, []byte("\xff\x0acodestream"))
+	data := slices.Concat(
+		JXL_SIGNATURE,
+		jxlBox(JXL_BOX_BROTLI, []byte("Exif\x8b\x01\x80compressed")),
+		jxlBox(JXL_BOX_BROTLI, []byte("xml \x8b\x01\x80compressed")),
+		jxlBox(JXL_BOX_BROTLI, []byte("jumb\x8b\x01\x80compressed")),
+		codestream,
+	)
+
+	sanitized, removed, err := Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{REMOVED_EXIF, REMOVED_XMP}; !slices.Equal(removed, expected) {
+		t.Fatalf("expected removed %v, got %v", expected, removed)
+	}
+	expected := slices.Concat(JXL_SIGNATURE, jxlBox(JXL_BOX_BROTLI, []byte("jumb\x8b\x01\x80compressed")), codestream)
+	if !bytes.Equal(sanitized, expected) {
+		t.Fatal("boxes other than EXIF and XMP are changed")
+	}
+}
+
+func TestSanitizeGif(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	var buffer bytes.Buffer
+	err := gif.EncodeAll(&buffer, &gif.GIF{
+		Image: []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 4, 4), palette)},
+		Delay: []int{0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := buffer.Bytes()
+
+	// XMP packets are followed by the magic trailer making them valid sub-blocks
+	xmp := slices.Concat([]byte{GIF_EXTENSION, GIF_LABEL_APP}, GIF_XMP_APP, FIXTURE_XMP, []byte{0x01})
+	for i := 0xFF; i >= 0; i-- {
+		xmp = append(xmp, byte(i))
+	}
+	xmp = append(xmp, 0)
+	// header, logical screen descriptor and the global colour table
+	header := 13 + gifColorTable(encoded[10])
+	data := slices.Concat(encoded[:header], xmp, encoded[header:])
+
+	sanitized, removed, err := Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, []string{REMOVED_XMP}) {
+		t.Fatalf("expected removed xmp, got %v", removed)
+	}
+	if !bytes.Equal(sanitized, encoded) {
+		t.Fatal("blocks other than XMP are changed")
+	}
+}
+
+func TestSanitizeWithoutMetadata(t *testing.T) {
+	for _, test := range fixtures {
+		t.Run(test.name, func(t *testing.T) {
+			f := test.build(t, exifFixture(binary.LittleEndian))
+			sanitized, _, err := Sanitize(f.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, removed, err := Sanitize(sanitized)
+			if err != nil || removed != nil || &again[0] != &sanitized[0] {
+				t.Fatal("data without private metadata is not returned as is")
+			}
+		})
+	}
+
+	for _, data := range [][]byte{nil, []byte("BM plain bitmap"), []byte("\xff\x0abare codestream")} {
+		result, removed, err := Sanitize(data)
+		if err != nil || removed != nil || !bytes.Equal(result, data) {
+			t.Fatalf("unknown data %q is changed", data)
+		}
+	}
+}
+
+func TestSanitizeTruncated(t *testing.T) {
+	for _, test := range fixtures {
+		for _, order := range orders {
+			t.Run(test.name+" "+order.name, func(t *testing.T) {
+				f := test.build(t, exifFixture(order.order))
+				complete := f.complete
+				if complete == 0 {
+					complete = len(f.data)
+				}
+
+				// shorter data is not recognised as an image
+				for length := 12; length < len(f.data); length++ {
+					_, _, err := Sanitize(f.data[:length])
+					switch {
+					case length < complete && !errors.Is(err, ErrMalformed):
+						t.Fatalf("expected %v for %d bytes, got %v", ErrMalformed, length, err)
+					case length >= complete && err != nil:
+						t.Fatalf("unexpected error for %d bytes: %v", length, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSanitizeMalformed(t *testing.T) {
+	exif := func(edit func(exif []byte, order binary.ByteOrder)) func(t *testing.T) []byte {
+		return func(t *testing.T) []byte {
+			data := exifFixture(binary.BigEndian)
+			edit(data, binary.BigEndian)
+			return jpegFixture(t, data).data
+		}
+	}
+	// entry of a tag of the fixture by its index
+	entry := func(ifd, index int) int {
+		return ifd + 2 + index*IFD_ENTRY_SIZE
+	}
+	edit := func(build func(t *testing.T, exif []byte) fixture, edit func(data []byte)) func(t *testing.T) []byte {
+		return func(t *testing.T) []byte {
+			data := build(t, exifFixture(binary.LittleEndian)).data
+			edit(data)
+			return data
+		}
+	}
+	at := func(data []byte, needle string) int {
+		return bytes.Index(data, []byte(needle))
+	}
+
+	tests := []struct {
+		name string
+		data func(t *testing.T) []byte
+	}{
+		{name: "exif byte order", data: exif(func(data []byte, order binary.ByteOrder) {
+			copy(data, "XX")
+		})},
+		{name: "exif magic number", data: exif(func(data []byte, order binary.ByteOrder) {
+			order.PutUint16(data[2:], 43)
+		})},
+		{name: "ifd0 out of data", data: exif(func(data []byte, order binary.ByteOrder) {
+			order.PutUint32(data[4:], FIXTURE_SIZE)
+		})},
+		{name: "entries out of data", data: exif(func(data []byte, order binary.ByteOrder) {
+			order.PutUint16(data[8:], 100)
+		})},
+		{name: "gps directory out of data", data: exif(func(data []byte, order binary.ByteOrder) {
+			order.PutUint32(data[entry(8, 2)+8:], FIXTURE_SIZE-4)
+		})},
+		{name: "exif directory out of data", data: exif(func(data []byte, order binary.ByteOrder) {
+			order.PutUint32(data[entry(8, 1)+8:], 0xFFFFFFFF)
+		})},
+		{name: "gps value out of data", data: exif(func(data []byte, order binary.ByteOrder) {
+			order.PutUint32(data[entry(FIXTURE_GPS_IFD, 1)+4:], 0x40000000)
+		})},
+		{name: "serial number out of data", data: exif(func(data []byte, order binary.ByteOrder) {
+			order.PutUint32(data[entry(8, 3)+8:], FIXTURE_SIZE-4)
+		})},
+		{name: "jpeg segment length", data: edit(jpegFixture, func(data []byte) {
+			binary.BigEndian.PutUint16(data[4:], 1)
+		})},
+		{name: "jpeg marker", data: edit(jpegFixture, func(data []byte) {
+			data[2] = 0
+		})},
+		{name: "png chunk length", data: edit(pngExifFixture, func(data []byte) {
+			binary.BigEndian.PutUint32(data[at(data, PNG_CHUNK_EXIF)-4:], 0x7FFFFFFF)
+		})},
+		{name: "png raw profile digit", data: edit(func(t *testing.T, exif []byte) fixture {
+			return pngRawProfileFixture(t, exif, PNG_CHUNK_TEXT)
+		}, func(data []byte) {
+			data[at(data, "\n45786966")+1] = 'x'
+		})},
+		{name: "png raw profile length", data: edit(func(t *testing.T, exif []byte) fixture {
+			return pngRawProfileFixture(t, exif, PNG_CHUNK_TEXT)
+		}, func(data []byte) {
+			copy(data[at(data, "\nexif\n")+6:], "     999")
+		})},
+		{name: "png raw profile stream", data: edit(func(t *testing.T, exif []byte) fixture {
+			return pngRawProfileFixture(t, exif, PNG_CHUNK_ZTXT)
+		}, func(data []byte) {
+			start := at(data, "Raw profile type exif\x00\x00") + 23
+			copy(data[start:], "broken stream")
+		})},
+		{name: "webp chunk length", data: edit(webpFixture, func(data []byte) {
+			binary.LittleEndian.PutUint32(data[at(data, WEBP_CHUNK_EXIF)+4:], 0x7FFFFFFF)
+		})},
+		{name: "webp vp8x length", data: edit(webpFixture, func(data []byte) {
+			binary.LittleEndian.PutUint32(data[at(data, WEBP_CHUNK_VP8X)+4:], 4)
+		})},
+		{name: "jxl box size", data: edit(jxlFixture, func(data []byte) {
+			binary.BigEndian.PutUint32(data[at(data, "ftyp")-4:], 4)
+		})},
+		{name: "jxl large box size", data: edit(jxlFixture, func(data []byte) {
+			binary.BigEndian.PutUint32(data[at(data, "ftyp")-4:], 1)
+		})},
+		{name: "jxl exif offset", data: edit(jxlFixture, func(data []byte) {
+			binary.BigEndian.PutUint32(data[at(data, JXL_BOX_EXIF)+4:], 0x7FFFFFFF)
+		})},
+		{name: "jxl without codestream", data: edit(jxlFixture, func(data []byte) {
+			copy(data[at(data, JXL_BOX_CODESTREAM):], "skip")
+		})},
+		{name: "gif block", data: func(t *testing.T) []byte {
+			return []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00\x00")
+		}},
+		{name: "gif colour table", data: func(t *testing.T) []byte {
+			return []byte("GIF89a\x01\x00\x01\x00\x87\x00\x00\x00\x00\x00")
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := Sanitize(test.data(t))
+			if !errors.Is(err, ErrMalformed) {
+				t.Fatalf("expected %v, got %v", ErrMalformed, err)
+			}
+		})
+	}
+}
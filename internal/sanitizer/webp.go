@@ -0,0 +1,71 @@
+package sanitizer
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	WEBP_CHUNK_VP8X = "VP8X"
+	WEBP_CHUNK_EXIF = "EXIF"
+	WEBP_CHUNK_XMP  = "XMP "
+	WEBP_FLAG_XMP   = 0x04
+)
+
+// sanitizeWebp rewrites chunks, EXIF chunks are edited and XMP ones are dropped with their VP8X flag
+func sanitizeWebp(data []byte, report *report) ([]byte, error) {
+	// the last padding byte is sometimes missing
+	if uint64(binary.LittleEndian.Uint32(data[4:]))+8 > uint64(len(data))+1 {
+		return nil, ErrMalformed
+	}
+
+	result := bytes.NewBuffer(make([]byte, 0, len(data)))
+	result.Write(data[:12])
+
+	vp8x, dropped := -1, false
+	position := 12
+	for position < len(data) {
+		if position+8 > len(data) {
+			return nil, ErrMalformed
+		}
+		length := uint64(binary.LittleEndian.Uint32(data[position+4:]))
+		// chunks are padded to even sizes, the last padding byte is sometimes missing
+		end := uint64(position) + 8 + length + length%2
+		if end > uint64(len(data)) {
+			if end-1 != uint64(len(data)) || length%2 == 0 {
+				return nil, ErrMalformed
+			}
+			end--
+		}
+		chunk := data[position:end]
+		position = int(end)
+
+		switch string(chunk[:4]) {
+		case WEBP_CHUNK_XMP:
+			report.add(REMOVED_XMP)
+			dropped = true
+			continue
+		case WEBP_CHUNK_EXIF:
+			chunk = bytes.Clone(chunk)
+			exif := chunk[8 : 8+length]
+			// some writers keep the JPEG header in the chunk
+			exif = bytes.TrimPrefix(exif, JPEG_EXIF_HEADER)
+			if err := sanitizeExif(exif, report); err != nil {
+				return nil, err
+			}
+		case WEBP_CHUNK_VP8X:
+			if length < 10 {
+				return nil, ErrMalformed
+			}
+			vp8x = result.Len()
+		}
+		result.Write(chunk)
+	}
+
+	sanitized := result.Bytes()
+	if vp8x >= 0 && dropped {
+		sanitized[vp8x+8] &^= WEBP_FLAG_XMP
+	}
+	binary.LittleEndian.PutUint32(sanitized[4:], uint32(len(sanitized)-8))
+	return sanitized, nil
+}
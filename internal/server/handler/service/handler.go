@@ -2,7 +2,11 @@ package service
 
 import (
 	"github.com/fasthttp/router"
+	"github.com/urvin/gokaru/internal/di"
+	"github.com/urvin/gokaru/internal/metrics"
+	"github.com/urvin/gokaru/internal/queue"
 	"github.com/urvin/gokaru/internal/server/helper"
+	"github.com/urvin/gokaru/internal/server/response"
 	"github.com/valyala/fasthttp"
 	"log/slog"
 )
@@ -14,6 +18,8 @@ type Handler struct {
 func (h *Handler) Register(router *router.Router) {
 	router.GET("/health", h.health)
 	router.GET("/favicon.ico", h.favicon)
+	router.GET("/metrics", h.metrics)
+	router.GET("/postprocessing/failed", h.failedPostprocessing)
 	router.NotFound = h.notfound
 	router.MethodNotAllowed = h.notallowed
 }
@@ -26,6 +32,45 @@ func (h *Handler) health(context *fasthttp.RequestCtx) {
 	}
 }
 
+func (h *Handler) metrics(context *fasthttp.RequestCtx) {
+	context.SetContentType("text/plain; version=0.0.4; charset=utf-8")
+	err := metrics.Write(context)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not write metrics")
+		h.Logger.Error(
+			"Could not write metrics",
+			"context", "server",
+			"handler", "metrics",
+			"error", err.Error(),
+		)
+	}
+}
+
+func (h *Handler) failedPostprocessing(context *fasthttp.RequestCtx) {
+	records, err := di.Get("queue").(*queue.Queue).FailedLaters()
+	if err == nil {
+		rsp := response.PostprocessingFailuresResponse{Failed: make([]response.PostprocessingFailureResponse, 0, len(records))}
+		for _, record := range records {
+			rsp.Failed = append(rsp.Failed, response.PostprocessingFailureResponse{
+				Thumbnail: record.Miniature.Hash(),
+				Attempts:  record.Attempts,
+				Error:     record.Error,
+				FailedAt:  record.FailedAt,
+			})
+		}
+		err = helper.WriteJsonContent(context, fasthttp.StatusOK, rsp)
+	}
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not list failed post-processing")
+		h.Logger.Error(
+			"Could not list failed post-processing",
+			"context", "server",
+			"handler", "failed_postprocessing",
+			"error", err.Error(),
+		)
+	}
+}
+
 func (h *Handler) favicon(context *fasthttp.RequestCtx) {
 	fasthttp.ServeFile(context, "/var/gokaru/assets/favicon.ico")
 }
@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/urvin/gokaru/internal/config"
+	"github.com/urvin/gokaru/internal/contracts"
+	helper2 "github.com/urvin/gokaru/internal/helper"
+	"github.com/urvin/gokaru/internal/queue"
+	"github.com/urvin/gokaru/internal/server/helper"
+	"github.com/urvin/gokaru/internal/server/response"
+	"github.com/valyala/fasthttp"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+var errBulkUnsupported = errors.New("body is neither multipart form nor tar or zip archive")
+
+// bulkEntryFunc receives every file of a bulk upload, err describes a file which could not be read
+type bulkEntryFunc func(fileName string, data []byte, err error)
+
+func (h *Handler) bulk(context *fasthttp.RequestCtx) {
+	sourceType := context.UserValue("sourceType").(string)
+	category := context.UserValue("category").(string)
+
+	rsp := response.BulkResponse{
+		Uploaded: []response.UploadResponse{},
+		Failed:   []response.BulkFailureResponse{},
+	}
+
+	// file names of stored origin names, so that files differing by extension only do not overwrite each other
+	names := make(map[string]string)
+	err := h.readBulk(context, func(fileName string, data []byte, err error) {
+		if err == nil {
+			var upload response.UploadResponse
+			upload, err = h.storeBulkEntry(sourceType, category, fileName, data, names)
+			if err == nil {
+				rsp.Uploaded = append(rsp.Uploaded, upload)
+				return
+			}
+		}
+
+		rsp.Failed = append(rsp.Failed, response.BulkFailureResponse{
+			Name:  fileName,
+			Error: err.Error(),
+		})
+		h.Logger.Warn(
+			"Could not upload bulk entry",
+			"context", "server",
+			"handler", "bulk",
+			"filename", fileName,
+			"error", err.Error(),
+		)
+	})
+	if err != nil && (len(rsp.Uploaded) > 0 || len(rsp.Failed) > 0) {
+		// files read before the failure are stored already, the rest of the body is reported as failed
+		rsp.Failed = append(rsp.Failed, response.BulkFailureResponse{
+			Error: "could not read the rest of the body: " + err.Error(),
+		})
+		h.Logger.Warn(
+			"Could not read the rest of bulk upload",
+			"context", "server",
+			"handler", "bulk",
+			"error", err.Error(),
+		)
+	} else if err != nil {
+		helper.ServeError(context, fasthttp.StatusBadRequest, "Could not read bulk upload")
+		h.Logger.Error(
+			"Could not read bulk upload",
+			"context", "server",
+			"handler", "bulk",
+			"error", err.Error(),
+		)
+		return
+	}
+
+	if len(rsp.Uploaded) == 0 && len(rsp.Failed) == 0 {
+		helper.ServeError(context, fasthttp.StatusBadRequest, "No files found")
+		h.Logger.Error(
+			"No files found",
+			"context", "server",
+			"handler", "bulk",
+		)
+		return
+	}
+
+	h.Logger.Info(
+		"Bulk upload processed",
+		"context", "server",
+		"handler", "bulk",
+		"category", category,
+		"uploaded", len(rsp.Uploaded),
+		"failed", len(rsp.Failed),
+	)
+
+	statusCode := fasthttp.StatusCreated
+	if len(rsp.Failed) > 0 {
+		statusCode = fasthttp.StatusMultiStatus
+	}
+	err = helper.WriteJsonContent(context, statusCode, rsp)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not describe bulk upload")
+		h.Logger.Error(
+			"Could not describe bulk upload",
+			"context", "server",
+			"handler", "bulk",
+			"error", err.Error(),
+		)
+	}
+}
+
+// storeBulkEntry stores a file of a bulk upload, names maps origin names stored by the upload to their file names
+func (h *Handler) storeBulkEntry(sourceType, category, fileName string, data []byte, names map[string]string) (rsp response.UploadResponse, err error) {
+	origin := &contracts.OriginDto{
+		Type:     sourceType,
+		Category: category,
+		Name:     h.bulkEntryName(sourceType, fileName),
+	}
+
+	if origin.Name == "" {
+		origin.Name, err = h.generateName(data)
+		if err != nil {
+			return
+		}
+	}
+	if !h.validName(origin.Type, origin.Name) {
+		err = errors.New("invalid name " + origin.Name)
+		return
+	}
+	if previous, ok := names[origin.Name]; ok {
+		err = errors.New("name " + origin.Name + " is taken by " + previous)
+		return
+	}
+
+	err = h.validate(origin, data)
+	if err != nil {
+		return
+	}
+
+	data, removed, err := h.sanitize(origin, data, "bulk")
+	if err != nil {
+		return
+	}
+
+	err = h.write(origin, data, "bulk")
+	if err != nil {
+		return
+	}
+	names[origin.Name] = fileName
+
+	rsp = h.uploadResponse(origin, data, "bulk")
+	rsp.RemovedMetadata = removed
+	rsp.Warmup = h.warmup(origin, queue.PRIORITY_BATCH)
+	return
+}
+
+// bulkEntryName makes an origin name of an archived or posted file name, empty if a name should be generated
+func (h *Handler) bulkEntryName(sourceType, fileName string) string {
+	name := path.Base(strings.ReplaceAll(fileName, "\\", "/"))
+	if sourceType == contracts.STORAGE_TYPE_IMAGE {
+		name = helper2.FileNameWithoutExtension(name)
+	}
+	if name == "." || name == ".." || name == "/" {
+		name = ""
+	}
+	return name
+}
+
+func (h *Handler) readBulk(context *fasthttp.RequestCtx, fn bulkEntryFunc) (err error) {
+	contentType := string(context.Request.Header.ContentType())
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		err = h.readBulkMultipart(context, fn)
+		return
+	}
+
+	body := context.Request.Body()
+	switch {
+	case bytes.HasPrefix(body, []byte("PK\x03\x04")):
+		err = h.readBulkZip(body, fn)
+	case bytes.HasPrefix(body, []byte{0x1f, 0x8b}):
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		err = h.readBulkTar(gz, fn)
+	case len(body) > 262 && bytes.Equal(body[257:262], []byte("ustar")):
+		err = h.readBulkTar(bytes.NewReader(body), fn)
+	default:
+		err = errBulkUnsupported
+	}
+	return
+}
+
+func (h *Handler) readBulkMultipart(context *fasthttp.RequestCtx, fn bulkEntryFunc) (err error) {
+	form, err := context.MultipartForm()
+	if err != nil {
+		return
+	}
+
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		for _, header := range form.File[field] {
+			file, er := header.Open()
+			if er != nil {
+				fn(header.Filename, nil, er)
+				continue
+			}
+			data, er := h.readBulkEntry(file)
+			_ = file.Close()
+			fn(header.Filename, data, er)
+		}
+	}
+	return
+}
+
+func (h *Handler) readBulkZip(body []byte, fn bulkEntryFunc) (err error) {
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return
+	}
+
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		reader, er := file.Open()
+		if er != nil {
+			fn(file.Name, nil, er)
+			continue
+		}
+		data, er := h.readBulkEntry(reader)
+		_ = reader.Close()
+		fn(file.Name, data, er)
+	}
+	return
+}
+
+func (h *Handler) readBulkTar(reader io.Reader, fn bulkEntryFunc) (err error) {
+	archive := tar.NewReader(reader)
+	for {
+		header, er := archive.Next()
+		if er == io.EOF {
+			return
+		}
+		if er != nil {
+			err = er
+			return
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, er := h.readBulkEntry(archive)
+		fn(header.Name, data, er)
+	}
+}
+
+// readBulkEntry reads an entry with the upload size limit, archives may unpack far beyond their own size
+func (h *Handler) readBulkEntry(reader io.Reader) (data []byte, err error) {
+	limit := int64(config.Get().MaxUploadSize) * 1024 * 1024
+	data, err = ioutil.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return
+	}
+	if int64(len(data)) > limit {
+		data = nil
+		err = errors.New("file is larger than upload size limit")
+	}
+	return
+}
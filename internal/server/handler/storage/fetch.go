@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"errors"
+	"github.com/urvin/gokaru/internal/contracts"
+	"github.com/urvin/gokaru/internal/di"
+	"github.com/urvin/gokaru/internal/fetcher"
+	"github.com/urvin/gokaru/internal/server/helper"
+	"github.com/valyala/fasthttp"
+)
+
+// fetch downloads an origin from a remote url and stores it under the given or a generated name
+func (h *Handler) fetch(context *fasthttp.RequestCtx) {
+	args := context.QueryArgs()
+	origin := &contracts.OriginDto{
+		Type:     context.UserValue("sourceType").(string),
+		Category: context.UserValue("category").(string),
+		Name:     string(args.Peek("filename")),
+	}
+	url := string(args.Peek("url"))
+
+	if url == "" || (origin.Name != "" && !h.validName(origin.Type, origin.Name)) {
+		helper.ServeError(context, fasthttp.StatusBadRequest, "Could not fetch origin")
+		h.Logger.Error(
+			"Invalid input data",
+			"context", "server",
+			"handler", "fetch",
+			"url", url,
+			"filename", origin.Name,
+		)
+		return
+	}
+
+	data, err := di.Get("fetcher").(fetcher.Fetcher).Fetch(url)
+	if err != nil {
+		code := fasthttp.StatusBadGateway
+		if errors.Is(err, fetcher.ErrInvalidUrl) || errors.Is(err, fetcher.ErrForbiddenHost) || errors.Is(err, fetcher.ErrTooManyRedirects) {
+			code = fasthttp.StatusBadRequest
+		} else if errors.Is(err, fetcher.ErrTooLarge) {
+			code = fasthttp.StatusRequestEntityTooLarge
+		}
+		helper.ServeError(context, code, "Could not fetch origin")
+		h.Logger.Error(
+			"Could not fetch origin",
+			"context", "server",
+			"handler", "fetch",
+			"url", url,
+			"error", err.Error(),
+		)
+		return
+	}
+
+	if origin.Name == "" {
+		origin.Name, err = h.generateName(data)
+		if err != nil {
+			helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not fetch origin")
+			h.Logger.Error(
+				"Could not generate name",
+				"context", "server",
+				"handler", "fetch",
+				"error", err.Error(),
+			)
+			return
+		}
+	}
+
+	stored, removed, ok := h.store(context, origin, data, "fetch")
+	if !ok {
+		return
+	}
+
+	h.respondUpload(context, origin, stored, removed, "fetch")
+}
@@ -1,27 +1,59 @@
 package storage
 
 import (
+	"errors"
 	"github.com/fasthttp/router"
+	"github.com/urvin/gokaru/internal/config"
 	"github.com/urvin/gokaru/internal/contracts"
 	"github.com/urvin/gokaru/internal/di"
+	helper2 "github.com/urvin/gokaru/internal/helper"
+	"github.com/urvin/gokaru/internal/queue"
+	"github.com/urvin/gokaru/internal/sanitizer"
+	"github.com/urvin/gokaru/internal/security"
 	"github.com/urvin/gokaru/internal/server/helper"
+	"github.com/urvin/gokaru/internal/server/response"
 	"github.com/urvin/gokaru/internal/storage"
+	"github.com/urvin/gokaru/internal/thumbnailer"
 	"github.com/valyala/fasthttp"
 	"log/slog"
+	"os"
+	"strings"
 )
 
+const LIST_LIMIT_DEFAULT = 100
+const LIST_LIMIT_MAX = 1000
+
 type Handler struct {
 	Logger *slog.Logger
 }
 
 func (h *Handler) Register(router *router.Router) {
+	router.GET("/{sourceType:^("+contracts.STORAGE_TYPE_FILE+"|"+contracts.STORAGE_TYPE_IMAGE+")$}", h.categories)
+	router.GET("/{sourceType:^"+contracts.STORAGE_TYPE_FILE+"$}/{category}", h.list)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_FILE+"$}/{category}", h.create)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_FILE+"$}/{category}/bulk", h.bulk)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_FILE+"$}/{category}/fetch", h.fetch)
 	router.PUT("/{sourceType:^"+contracts.STORAGE_TYPE_FILE+"$}/{category}/{filename}", h.upload)
 	router.DELETE("/{sourceType:^"+contracts.STORAGE_TYPE_FILE+"$}/{category}/{filename}", h.remove)
 	router.GET("/{sourceType:^"+contracts.STORAGE_TYPE_FILE+"$}/{category}/{filename}", h.origin)
+	router.GET("/{sourceType:^"+contracts.STORAGE_TYPE_FILE+"$}/{category}/{filename}/versions", h.versions)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_FILE+"$}/{category}/{filename}/restore", h.restore)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_FILE+"$}/{category}/{filename}/copy", h.copy)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_FILE+"$}/{category}/{filename}/move", h.move)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_FILE+"$}/{category}/{filename}/rename", h.rename)
 
+	router.GET("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{category}", h.list)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{category}", h.create)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{category}/bulk", h.bulk)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{category}/fetch", h.fetch)
 	router.PUT("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{category}/{filename:^[^\\.]+$}", h.upload)
 	router.DELETE("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{category}/{filename:^[^\\.]+$}", h.remove)
 	router.GET("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{category}/{filename:^[^\\.]+$}", h.origin)
+	router.GET("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{category}/{filename:^[^\\.]+$}/versions", h.versions)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{category}/{filename:^[^\\.]+$}/restore", h.restore)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{category}/{filename:^[^\\.]+$}/copy", h.copy)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{category}/{filename:^[^\\.]+$}/move", h.move)
+	router.POST("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{category}/{filename:^[^\\.]+$}/rename", h.rename)
 }
 
 func (h *Handler) upload(context *fasthttp.RequestCtx) {
@@ -37,46 +69,302 @@ func (h *Handler) upload(context *fasthttp.RequestCtx) {
 		return
 	}
 
+	stored, removed, ok := h.store(context, origin, context.Request.Body(), "upload")
+	if !ok {
+		return
+	}
+
+	h.respondUpload(context, origin, stored, removed, "upload")
+}
+
+func (h *Handler) create(context *fasthttp.RequestCtx) {
+	origin := &contracts.OriginDto{
+		Type:     context.UserValue("sourceType").(string),
+		Category: context.UserValue("category").(string),
+	}
+
 	uploadedData := context.Request.Body()
 
-	if origin.Type == contracts.STORAGE_TYPE_IMAGE {
-		contentType := helper.MimeByData(uploadedData)
+	var err error
+	origin.Name, err = h.generateName(uploadedData)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not upload origin")
+		h.Logger.Error(
+			"Could not generate name",
+			"context", "server",
+			"handler", "create",
+			"error", err.Error(),
+		)
+		return
+	}
 
-		if contentType != "image/bmp" &&
-			contentType != "image/gif" &&
-			contentType != "image/webp" &&
-			contentType != "image/png" &&
-			contentType != "image/jpeg" {
-			helper.ServeError(context, fasthttp.StatusBadRequest, "Uploaded file is not an image")
+	var removed []string
+	if config.Get().NameGenerator == contracts.NAME_GENERATOR_HASH && h.storage().Exists(origin) {
+		// content addressed names point to the same upload, nothing to overwrite, the stored origin is described
+		stored, err := h.storage().Read(origin)
+		if err != nil {
+			helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not upload origin")
 			h.Logger.Error(
-				"Uploaded file is not an image",
+				"Could not read stored origin",
 				"context", "server",
-				"handler", "upload",
-				"content_type", contentType,
+				"handler", "create",
+				"error", err.Error(),
 			)
 			return
 		}
+		uploadedData = stored.Contents
+	} else {
+		var ok bool
+		uploadedData, removed, ok = h.store(context, origin, uploadedData, "create")
+		if !ok {
+			return
+		}
+	}
+
+	h.respondUpload(context, origin, uploadedData, removed, "create")
+}
+
+// respondUpload describes a stored origin and warms its thumbnails up
+func (h *Handler) respondUpload(context *fasthttp.RequestCtx, origin *contracts.OriginDto, uploadedData []byte, removed []string, handler string) {
+	rsp := h.uploadResponse(origin, uploadedData, handler)
+	rsp.RemovedMetadata = removed
+	rsp.Warmup = h.warmup(origin, queue.PRIORITY_WARMUP)
+	err := helper.WriteJsonContent(context, fasthttp.StatusCreated, rsp)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not describe origin")
+		h.Logger.Error(
+			"Could not describe origin",
+			"context", "server",
+			"handler", handler,
+			"error", err.Error(),
+		)
 	}
+}
 
-	err = h.storage().Write(origin, uploadedData)
+// store validates, sanitizes and writes an uploaded origin, returns stored data and kinds of removed metadata, serves
+// an error on failure
+func (h *Handler) store(context *fasthttp.RequestCtx, origin *contracts.OriginDto, uploadedData []byte, handler string) (stored []byte, removed []string, ok bool) {
+	err := h.validate(origin, uploadedData)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusBadRequest, "Uploaded file is not an image")
+		h.Logger.Error(
+			"Uploaded file is not an image",
+			"context", "server",
+			"handler", handler,
+			"error", err.Error(),
+		)
+		return
+	}
+
+	stored, removed, err = h.sanitize(origin, uploadedData, handler)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusBadRequest, "Could not strip image metadata")
+		h.Logger.Error(
+			"Could not strip image metadata",
+			"context", "server",
+			"handler", handler,
+			"error", err.Error(),
+		)
+		return
+	}
+
+	err = h.write(origin, stored, handler)
 	if err != nil {
 		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not upload origin")
 		h.Logger.Error(
 			"Could not upload file",
 			"context", "server",
-			"handler", "upload",
+			"handler", handler,
 			"error", err.Error(),
 		)
 		return
 	}
+	ok = true
+	return
+}
+
+// sanitize strips private metadata of image origins of categories requiring it, data is returned as is otherwise
+func (h *Handler) sanitize(origin *contracts.OriginDto, uploadedData []byte, handler string) (sanitized []byte, removed []string, err error) {
+	if origin.Type != contracts.STORAGE_TYPE_IMAGE || !config.Get().Category(origin.Category).StripPrivateMetadata {
+		return uploadedData, nil, nil
+	}
+
+	sanitized, removed, err = sanitizer.Sanitize(uploadedData)
+	if err == nil && len(removed) > 0 {
+		h.Logger.Info(
+			"Private metadata removed",
+			"context", "server",
+			"handler", handler,
+			"filename", origin.Category+"/"+origin.Name,
+			"removed", strings.Join(removed, ","),
+		)
+	}
+	return
+}
+
+func (h *Handler) validate(origin *contracts.OriginDto, uploadedData []byte) (err error) {
+	if origin.Type != contracts.STORAGE_TYPE_IMAGE {
+		return
+	}
+
+	contentType := helper.MimeByData(uploadedData)
+
+	if contentType != "image/bmp" &&
+		contentType != "image/gif" &&
+		contentType != "image/webp" &&
+		contentType != "image/png" &&
+		contentType != "image/jpeg" &&
+		(contentType != "image/jxl" || !thumbnailer.SupportsInput("jxl")) {
+		err = errors.New("unsupported content type " + contentType)
+	}
+	return
+}
+
+// write stores an origin replacing thumbnails of the previous one
+func (h *Handler) write(origin *contracts.OriginDto, uploadedData []byte, handler string) (err error) {
+	err = h.replace(func() error {
+		return h.storage().Write(origin, uploadedData)
+	}, origin)
+	if err != nil {
+		return
+	}
 
 	h.Logger.Info(
 		"File uploaded",
 		"context", "server",
-		"handler", "upload",
+		"handler", handler,
 		"filename", origin.Category+"/"+origin.Name,
 	)
-	context.SetStatusCode(fasthttp.StatusCreated)
+	return
+}
+
+func (h *Handler) generateName(data []byte) (name string, err error) {
+	if config.Get().NameGenerator == contracts.NAME_GENERATOR_HASH {
+		name = helper2.ContentHash(data)
+		return
+	}
+	name, err = helper2.Uuid()
+	return
+}
+
+// uploadResponse describes a stored origin, images libvips could not inspect are described without dimensions and
+// thumbnails as they are stored anyway
+func (h *Handler) uploadResponse(origin *contracts.OriginDto, data []byte, handler string) (rsp response.UploadResponse) {
+	rsp = response.UploadResponse{
+		Type:        origin.Type,
+		Category:    origin.Category,
+		Name:        origin.Name,
+		Url:         "/" + origin.Type + "/" + origin.Category + "/" + origin.Name,
+		Size:        len(data),
+		ContentType: helper.MimeByData(data),
+	}
+
+	if origin.Type != contracts.STORAGE_TYPE_IMAGE {
+		return
+	}
+
+	info, err := h.queue().OriginInfo(origin)
+	if err != nil {
+		h.Logger.Warn(
+			"Could not inspect uploaded image",
+			"context", "server",
+			"handler", handler,
+			"filename", origin.Category+"/"+origin.Name,
+			"error", err.Error(),
+		)
+		return
+	}
+	rsp.Width = info.Width
+	rsp.Height = info.Height
+
+	sg := di.Get("signature").(security.SignatureGenerator)
+
+	rsp.Thumbnails = make(map[string]string)
+	for _, miniature := range h.presetMiniatures(origin) {
+		rsp.Thumbnails[miniature.Preset] = helper.ThumbnailUrl(miniature, sg.Sign(miniature))
+	}
+	return
+}
+
+func (h *Handler) presetMiniatures(origin *contracts.OriginDto) []*contracts.MiniatureDto {
+	presets := config.Get().Presets
+	miniatures := make([]*contracts.MiniatureDto, len(presets))
+	for i, preset := range presets {
+		miniatures[i] = helper.PresetMiniature(origin, preset, "")
+	}
+	return miniatures
+}
+
+// warmup enqueues thumbnails of presets configured for warmup in the origin category
+func (h *Handler) warmup(origin *contracts.OriginDto, priority queue.Priority) (status string) {
+	if origin.Type != contracts.STORAGE_TYPE_IMAGE {
+		return
+	}
+
+	for _, name := range config.Get().Category(origin.Category).Warmup {
+		preset, ok := config.Get().Preset(name)
+		if !ok {
+			h.Logger.Warn(
+				"Unknown warmup preset "+name,
+				"context", "server",
+				"handler", "warmup",
+				"category", origin.Category,
+			)
+			continue
+		}
+
+		miniature := helper.PresetMiniature(origin, preset, "")
+		if !h.resolveAuto(miniature) {
+			continue
+		}
+
+		if status == "" {
+			status = contracts.WARMUP_STATUS_QUEUED
+		}
+		if !h.enqueue(miniature, priority) {
+			status = contracts.WARMUP_STATUS_DROPPED
+		}
+	}
+	return
+}
+
+// resolveAuto replaces the auto format of a preset thumbnail with the one of clients without explicit Accept formats,
+// false if the origin could not be inspected
+func (h *Handler) resolveAuto(miniature *contracts.MiniatureDto) bool {
+	if miniature.Extension != thumbnailer.EXTENSION_AUTO {
+		return true
+	}
+
+	info, err := h.queue().OriginInfo(&contracts.OriginDto{
+		Type:     miniature.Type,
+		Category: miniature.Category,
+		Name:     miniature.Name,
+	})
+	if err != nil {
+		return false
+	}
+	miniature.Extension = info.Format
+	return true
+}
+
+func (h *Handler) enqueue(miniature *contracts.MiniatureDto, priority queue.Priority) bool {
+	if priority == queue.PRIORITY_BATCH {
+		return h.queue().Batch(miniature)
+	}
+	return h.queue().Warmup(miniature)
+}
+
+// validName checks an origin name could be requested by routes
+func (h *Handler) validName(sourceType, name string) bool {
+	if name == "" || strings.Contains(name, "/") {
+		return false
+	}
+	return sourceType != contracts.STORAGE_TYPE_IMAGE || !strings.Contains(name, ".")
+}
+
+// validCategory checks a category of a query argument is a single path segment, like categories bound by routes
+func (h *Handler) validCategory(category string) bool {
+	return category != "" && category != "." && category != ".." && !strings.ContainsAny(category, "/\\")
 }
 
 func (h *Handler) origin(context *fasthttp.RequestCtx) {
@@ -92,7 +380,22 @@ func (h *Handler) origin(context *fasthttp.RequestCtx) {
 		return
 	}
 
-	info, err := h.storage().Read(origin)
+	var info contracts.FileDto
+	if version := string(context.QueryArgs().Peek("version")); version != "" {
+		info, err = h.storage().ReadVersion(origin, version)
+	} else {
+		info, err = h.storage().Read(origin)
+	}
+	if os.IsNotExist(err) || errors.Is(err, storage.ErrInvalidVersion) {
+		helper.ServeError(context, fasthttp.StatusNotFound, "Could not find origin")
+		h.Logger.Warn(
+			"Origin not found",
+			"context", "server",
+			"handler", "origin",
+			"error", err.Error(),
+		)
+		return
+	}
 	if err != nil {
 		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not return origin")
 		h.Logger.Error(
@@ -131,7 +434,9 @@ func (h *Handler) remove(context *fasthttp.RequestCtx) {
 		return
 	}
 
-	err = h.storage().Remove(origin)
+	err = h.replace(func() error {
+		return h.storage().Remove(origin)
+	}, origin)
 	if err != nil {
 		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not remove file")
 		h.Logger.Error(
@@ -152,6 +457,188 @@ func (h *Handler) remove(context *fasthttp.RequestCtx) {
 	context.SetStatusCode(fasthttp.StatusNoContent)
 }
 
+func (h *Handler) versions(context *fasthttp.RequestCtx) {
+	origin, err := helper.GetOriginInfoFromContext(context)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusBadRequest, "Could not list versions")
+		h.Logger.Error(
+			"Invalid input data",
+			"context", "server",
+			"handler", "versions",
+			"error", err.Error(),
+		)
+		return
+	}
+
+	versions, err := h.storage().Versions(origin)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not list versions")
+		h.Logger.Error(
+			"Could not list versions",
+			"context", "server",
+			"handler", "versions",
+			"error", err.Error(),
+		)
+		return
+	}
+
+	rsp := response.VersionsResponse{
+		Versions: make([]response.VersionResponse, len(versions)),
+	}
+	for i, version := range versions {
+		rsp.Versions[i] = response.VersionResponse{
+			Version:          version.Id,
+			Size:             version.Size,
+			ModificationTime: version.ModificationTime.UTC(),
+		}
+	}
+
+	err = helper.WriteJsonContent(context, fasthttp.StatusOK, rsp)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not list versions")
+		h.Logger.Error(
+			"Could not list versions",
+			"context", "server",
+			"handler", "versions",
+			"error", err.Error(),
+		)
+	}
+}
+
+func (h *Handler) restore(context *fasthttp.RequestCtx) {
+	origin, err := helper.GetOriginInfoFromContext(context)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusBadRequest, "Could not restore version")
+		h.Logger.Error(
+			"Invalid input data",
+			"context", "server",
+			"handler", "restore",
+			"error", err.Error(),
+		)
+		return
+	}
+
+	version := string(context.QueryArgs().Peek("version"))
+
+	err = h.replace(func() error {
+		return h.storage().RestoreVersion(origin, version)
+	}, origin)
+	if os.IsNotExist(err) || errors.Is(err, storage.ErrInvalidVersion) {
+		helper.ServeError(context, fasthttp.StatusNotFound, "Could not find version")
+		h.Logger.Warn(
+			"Version not found",
+			"context", "server",
+			"handler", "restore",
+			"version", version,
+		)
+		return
+	}
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not restore version")
+		h.Logger.Error(
+			"Could not restore version",
+			"context", "server",
+			"handler", "restore",
+			"error", err.Error(),
+		)
+		return
+	}
+
+	h.Logger.Info(
+		"Version restored",
+		"context", "server",
+		"handler", "restore",
+		"filename", origin.Category+"/"+origin.Name,
+		"version", version,
+	)
+	context.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+func (h *Handler) categories(context *fasthttp.RequestCtx) {
+	sourceType := context.UserValue("sourceType").(string)
+
+	categories, err := h.storage().Categories(sourceType)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not list categories")
+		h.Logger.Error(
+			"Could not list categories",
+			"context", "server",
+			"handler", "categories",
+			"error", err.Error(),
+		)
+		return
+	}
+
+	err = helper.WriteJsonContent(context, fasthttp.StatusOK, response.CategoriesResponse{Categories: categories})
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not list categories")
+		h.Logger.Error(
+			"Could not list categories",
+			"context", "server",
+			"handler", "categories",
+			"error", err.Error(),
+		)
+	}
+}
+
+func (h *Handler) list(context *fasthttp.RequestCtx) {
+	sourceType := context.UserValue("sourceType").(string)
+	category := context.UserValue("category").(string)
+
+	args := context.QueryArgs()
+	limit := helper2.Atoi(string(args.Peek("limit")))
+	if limit <= 0 || limit > LIST_LIMIT_MAX {
+		limit = LIST_LIMIT_DEFAULT
+	}
+
+	entries, nextCursor, err := h.storage().List(sourceType, category, string(args.Peek("prefix")), string(args.Peek("cursor")), limit)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not list files")
+		h.Logger.Error(
+			"Could not list files",
+			"context", "server",
+			"handler", "list",
+			"error", err.Error(),
+		)
+		return
+	}
+
+	rsp := response.ListResponse{
+		Files:      make([]response.EntryResponse, len(entries)),
+		NextCursor: nextCursor,
+	}
+	for i, entry := range entries {
+		rsp.Files[i] = response.EntryResponse{
+			Name:             entry.Name,
+			Size:             entry.Size,
+			ModificationTime: entry.ModificationTime.UTC(),
+		}
+	}
+
+	err = helper.WriteJsonContent(context, fasthttp.StatusOK, rsp)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not list files")
+		h.Logger.Error(
+			"Could not list files",
+			"context", "server",
+			"handler", "list",
+			"error", err.Error(),
+		)
+	}
+}
+
+// replace runs a storage change of image origins, so that no thumbnail of the previous images survives it
+func (h *Handler) replace(fn func() error, origins ...*contracts.OriginDto) error {
+	if origins[0].Type != contracts.STORAGE_TYPE_IMAGE {
+		return fn()
+	}
+	return h.queue().Replace(fn, origins...)
+}
+
 func (h *Handler) storage() storage.Storage {
 	return di.Get("storage").(storage.Storage)
 }
+
+func (h *Handler) queue() *queue.Queue {
+	return di.Get("queue").(*queue.Queue)
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"github.com/urvin/gokaru/internal/contracts"
+	"github.com/urvin/gokaru/internal/server/helper"
+	"github.com/valyala/fasthttp"
+	"os"
+)
+
+const THUMBNAILS_REGENERATE = "regenerate"
+
+func (h *Handler) copy(context *fasthttp.RequestCtx) {
+	h.relocate(context, "copy", false, false)
+}
+
+func (h *Handler) move(context *fasthttp.RequestCtx) {
+	h.relocate(context, "move", true, false)
+}
+
+func (h *Handler) rename(context *fasthttp.RequestCtx) {
+	h.relocate(context, "rename", true, true)
+}
+
+// relocate copies or moves an origin to a name and a category from query arguments, both default to the source ones
+func (h *Handler) relocate(context *fasthttp.RequestCtx, handler string, move bool, sameCategory bool) {
+	source, err := helper.GetOriginInfoFromContext(context)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusBadRequest, "Could not "+handler+" origin")
+		h.Logger.Error(
+			"Invalid input data",
+			"context", "server",
+			"handler", handler,
+			"error", err.Error(),
+		)
+		return
+	}
+
+	args := context.QueryArgs()
+	destination := &contracts.OriginDto{
+		Type:     source.Type,
+		Category: string(args.Peek("category")),
+		Name:     string(args.Peek("filename")),
+	}
+	if destination.Category == "" || sameCategory {
+		destination.Category = source.Category
+	}
+	if destination.Name == "" {
+		destination.Name = source.Name
+	}
+
+	if !h.validName(destination.Type, destination.Name) || !h.validCategory(destination.Category) || *destination == *source {
+		helper.ServeError(context, fasthttp.StatusBadRequest, "Invalid destination")
+		h.Logger.Error(
+			"Invalid destination",
+			"context", "server",
+			"handler", handler,
+			"destination", destination.Category+"/"+destination.Name,
+		)
+		return
+	}
+
+	carry := string(args.Peek("thumbnails")) != THUMBNAILS_REGENERATE
+
+	if move {
+		err = h.replace(func() error {
+			return h.storage().Move(source, destination, carry)
+		}, source, destination)
+	} else {
+		err = h.replace(func() error {
+			return h.storage().Copy(source, destination, carry)
+		}, destination)
+	}
+	if os.IsNotExist(err) {
+		helper.ServeError(context, fasthttp.StatusNotFound, "Could not find origin")
+		h.Logger.Warn(
+			"Origin not found",
+			"context", "server",
+			"handler", handler,
+			"error", err.Error(),
+		)
+		return
+	}
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not "+handler+" origin")
+		h.Logger.Error(
+			"Could not "+handler+" origin",
+			"context", "server",
+			"handler", handler,
+			"error", err.Error(),
+		)
+		return
+	}
+
+	if !carry && destination.Type == contracts.STORAGE_TYPE_IMAGE {
+		h.regenerate(destination)
+	}
+
+	h.Logger.Info(
+		"Origin relocated",
+		"context", "server",
+		"handler", handler,
+		"source", source.Category+"/"+source.Name,
+		"destination", destination.Category+"/"+destination.Name,
+	)
+	context.Response.Header.Set(fasthttp.HeaderLocation, "/"+destination.Type+"/"+destination.Category+"/"+destination.Name)
+	context.SetStatusCode(fasthttp.StatusCreated)
+}
+
+// regenerate enqueues preset thumbnails of an origin for background processing
+func (h *Handler) regenerate(origin *contracts.OriginDto) {
+	for _, miniature := range h.presetMiniatures(origin) {
+		if !h.resolveAuto(miniature) {
+			continue
+		}
+		if !h.queue().Batch(miniature) {
+			h.Logger.Warn(
+				"Warmup backlog is full",
+				"context", "server",
+				"handler", "regenerate",
+				"thumbnail", miniature.Hash(),
+			)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package thumbnail
 
 import (
+	ctx "context"
+	"errors"
 	"github.com/fasthttp/router"
 	"github.com/urvin/gokaru/internal/config"
 	"github.com/urvin/gokaru/internal/contracts"
@@ -8,9 +10,12 @@ import (
 	"github.com/urvin/gokaru/internal/queue"
 	"github.com/urvin/gokaru/internal/security"
 	"github.com/urvin/gokaru/internal/server/helper"
+	"github.com/urvin/gokaru/internal/thumbnailer"
 	"github.com/valyala/fasthttp"
 	"log/slog"
-	"strings"
+	"math"
+	"slices"
+	"strconv"
 )
 
 type Handler struct {
@@ -19,6 +24,7 @@ type Handler struct {
 
 func (h *Handler) Register(router *router.Router) {
 	router.GET("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{signature}/{category}/{width:[0-9]+}/{height:[0-9]+}/{cast:[0-9]+}/{filename}", h.thumbnail)
+	router.GET("/{sourceType:^"+contracts.STORAGE_TYPE_IMAGE+"$}/{signature}/{category}/{preset}/{filename}", h.preset)
 }
 
 func (h *Handler) thumbnail(context *fasthttp.RequestCtx) {
@@ -34,6 +40,86 @@ func (h *Handler) thumbnail(context *fasthttp.RequestCtx) {
 		return
 	}
 
+	if !miniature.Trim.IsZero() && miniature.Cast&thumbnailer.CAST_TRIM == 0 {
+		helper.ServeError(context, fasthttp.StatusBadRequest, "Trim settings need trim cast")
+		h.Logger.Warn(
+			"Trim settings without trim cast",
+			"context", "server",
+			"handler", "thumbnail",
+			"cast", miniature.Cast,
+		)
+		return
+	}
+
+	if config.Get().Category(miniature.Category).PresetsOnly {
+		helper.ServeError(context, fasthttp.StatusForbidden, "Only presets are allowed")
+		h.Logger.Warn(
+			"Arbitrary size in presets only category",
+			"context", "server",
+			"handler", "thumbnail",
+			"category", miniature.Category,
+		)
+		return
+	}
+
+	err = h.checkSize(miniature)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusBadRequest, "Thumbnail size is not allowed")
+		h.Logger.Warn(
+			"Thumbnail size is not allowed",
+			"context", "server",
+			"handler", "thumbnail",
+			"category", miniature.Category,
+			"error", err.Error(),
+		)
+		return
+	}
+
+	h.serve(context, miniature, "thumbnail")
+}
+
+// checkSize enforces dimension bounds and category allow-lists of sizes and casts, presets are trusted
+func (h *Handler) checkSize(miniature *contracts.MiniatureDto) error {
+	cfg := config.Get()
+	if cfg.MaxWidth > 0 && miniature.Width > cfg.MaxWidth {
+		return errors.New("width exceeds " + strconv.Itoa(cfg.MaxWidth))
+	}
+	if cfg.MaxHeight > 0 && miniature.Height > cfg.MaxHeight {
+		return errors.New("height exceeds " + strconv.Itoa(cfg.MaxHeight))
+	}
+	if cfg.MaxArea > 0 && float64(miniature.Width)*float64(miniature.Height) > float64(cfg.MaxArea) {
+		return errors.New("area exceeds " + strconv.Itoa(cfg.MaxArea))
+	}
+
+	category := cfg.Category(miniature.Category)
+	size := config.Size{Width: miniature.Width, Height: miniature.Height}
+	if len(category.Sizes) > 0 && !slices.Contains(category.Sizes, size) {
+		return errors.New("size " + strconv.Itoa(size.Width) + "x" + strconv.Itoa(size.Height) + " is not in the list")
+	}
+	if len(category.Casts) > 0 && !slices.Contains(category.Casts, miniature.Cast) {
+		return errors.New("cast " + strconv.Itoa(miniature.Cast) + " is not in the list")
+	}
+	return nil
+}
+
+func (h *Handler) preset(context *fasthttp.RequestCtx) {
+	miniature, err := helper.GetPresetMiniatureInfoFromContext(context)
+	if err != nil {
+		helper.ServeError(context, fasthttp.StatusNotFound, "Could not thumbnail origin")
+		h.Logger.Error(
+			"Invalid input data",
+			"context", "server",
+			"handler", "preset",
+			"error", err.Error(),
+		)
+		return
+	}
+
+	h.serve(context, miniature, "preset")
+}
+
+// serve checks the signature of a requested thumbnail and responds with it
+func (h *Handler) serve(context *fasthttp.RequestCtx, miniature *contracts.MiniatureDto, handler string) {
 	sg := di.Get("signature").(security.SignatureGenerator)
 
 	signature := context.UserValue("signature").(string)
@@ -45,34 +131,76 @@ func (h *Handler) thumbnail(context *fasthttp.RequestCtx) {
 		h.Logger.Warn(
 			"Signature mismatch",
 			"context", "server",
-			"handler", "thumbnail",
+			"handler", handler,
 			"signature", signature,
 			"calculated_signature", generatedSignature,
 		)
 		return
 	}
 
-	if config.Get().EnforceWebp && miniature.Extension != "webp" {
-		httpAccept := string(context.Request.Header.Peek(fasthttp.HeaderAccept))
-		if strings.Contains(httpAccept, "webp") {
-			miniature.Extension = "webp"
-			context.Response.Header.Set(fasthttp.HeaderVary, "Accept")
-		}
+	if miniature.Extension == thumbnailer.EXTENSION_AUTO {
+		h.auto(context, miniature)
+	} else {
+		h.negotiate(context, miniature, &originInfo{miniature: miniature})
+	}
+
+	if !thumbnailer.SupportsOutput(miniature.Extension) {
+		helper.ServeError(context, fasthttp.StatusBadRequest, "Thumbnail format is not supported")
+		h.Logger.Warn(
+			"Thumbnail format is not supported",
+			"context", "server",
+			"handler", handler,
+			"extension", miniature.Extension,
+		)
+		return
 	}
 
 	q := di.Get("queue").(*queue.Queue)
-	thumbnail, err := q.GetThumbnail(miniature)
+	// shutdown lets requests in progress finish, they end when their client disconnects or the queue gives up on them
+	requestCtx, cancel := helper.ClientContext(context, q.Timeout())
+	defer cancel()
+
+	thumbnail, err := q.GetThumbnail(requestCtx, miniature)
+	if errors.Is(err, ctx.Canceled) {
+		h.Logger.Info(
+			"Client disconnected",
+			"context", "server",
+			"handler", handler,
+			"thumbnail", miniature.Hash(),
+		)
+		return
+	}
+	if errors.Is(err, queue.ErrQueueFull) || errors.Is(err, queue.ErrWaitTimeout) || errors.Is(err, queue.ErrJobTimeout) || errors.Is(err, queue.ErrClosed) || errors.Is(err, ctx.DeadlineExceeded) {
+		code := fasthttp.StatusServiceUnavailable
+		if errors.Is(err, queue.ErrJobTimeout) || errors.Is(err, ctx.DeadlineExceeded) {
+			code = fasthttp.StatusGatewayTimeout
+		}
+		context.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(q.RetryAfter().Seconds()))))
+		helper.ServeError(context, code, "Thumbnail queue is busy")
+		h.Logger.Warn(
+			"Thumbnail queue is busy",
+			"context", "server",
+			"handler", handler,
+			"thumbnail", miniature.Hash(),
+			"error", err.Error(),
+		)
+		return
+	}
 	if err != nil {
 		helper.ServeError(context, fasthttp.StatusInternalServerError, "Could not process thumbnail")
 		h.Logger.Error(
 			"Thumbnail processing error",
 			"context", "server",
-			"handler", "thumbnail",
+			"handler", handler,
 			"error", err.Error(),
 		)
 		return
 	}
 
+	if thumbnail.Quality > 0 {
+		context.Response.Header.Set(helper.HEADER_QUALITY, strconv.FormatUint(uint64(thumbnail.Quality), 10))
+	}
+
 	if thumbnail.Size == 0 {
 		err = helper.ServeBytes(context, thumbnail.Contents, miniature.Extension)
 	} else {
@@ -83,10 +211,8 @@ func (h *Handler) thumbnail(context *fasthttp.RequestCtx) {
 		h.Logger.Error(
 			"Thumbnail showing error",
 			"context", "server",
-			"handler", "thumbnail",
+			"handler", handler,
 			"error", err.Error(),
 		)
 	}
-
-	return
 }
@@ -0,0 +1,114 @@
+package thumbnail
+
+import (
+	"github.com/urvin/gokaru/internal/config"
+	"github.com/urvin/gokaru/internal/contracts"
+	"github.com/urvin/gokaru/internal/di"
+	"github.com/urvin/gokaru/internal/queue"
+	"github.com/urvin/gokaru/internal/server/helper"
+	"github.com/urvin/gokaru/internal/thumbnailer"
+	"github.com/valyala/fasthttp"
+	"mime"
+	"slices"
+)
+
+// originInfo reads stored facts of the origin of a thumbnail once, on demand
+type originInfo struct {
+	miniature *contracts.MiniatureDto
+	read      bool
+	info      contracts.OriginInfoDto
+	err       error
+}
+
+func (o *originInfo) get() (contracts.OriginInfoDto, error) {
+	if !o.read {
+		o.info, o.err = di.Get("queue").(*queue.Queue).OriginInfo(&contracts.OriginDto{
+			Type:     o.miniature.Type,
+			Category: o.miniature.Category,
+			Name:     o.miniature.Name,
+		})
+		o.read = true
+	}
+	return o.info, o.err
+}
+
+// auto picks a thumbnail format by origin content, photos and images with alpha are negotiated further, indexed
+// colour graphics are kept lossless
+func (h *Handler) auto(context *fasthttp.RequestCtx, miniature *contracts.MiniatureDto) {
+	// the response depends on Accept whichever format is picked
+	context.Response.Header.Set(fasthttp.HeaderVary, "Accept")
+
+	origin := &originInfo{miniature: miniature}
+	info, err := origin.get()
+	if err != nil {
+		// unreadable origins are left to fail later
+		miniature.Extension = "jpg"
+		return
+	}
+
+	miniature.Extension = info.Format
+	if info.Palette && !info.Animated {
+		return
+	}
+	h.negotiate(context, miniature, origin)
+}
+
+// format capabilities of the thumbnailer, replaced in tests
+var (
+	supportsOutput    = thumbnailer.SupportsOutput
+	supportsAnimation = thumbnailer.SupportsAnimation
+	supportsAlpha     = thumbnailer.SupportsAlpha
+)
+
+// negotiate replaces the requested format with the most acceptable of preferred formats of the category
+func (h *Handler) negotiate(context *fasthttp.RequestCtx, miniature *contracts.MiniatureDto, origin *originInfo) {
+	formats := config.Get().PreferredFormatsOf(miniature.Category)
+	if !slices.ContainsFunc(formats, func(format string) bool { return format != config.FORMAT_ORIGINAL }) {
+		return
+	}
+
+	// the response depends on Accept whichever format is picked
+	context.Response.Header.Set(fasthttp.HeaderVary, "Accept")
+
+	accept := helper.ParseAccept(string(context.Request.Header.Peek(fasthttp.HeaderAccept)))
+	miniature.Extension = negotiateFormat(accept, formats, miniature.Extension, origin)
+}
+
+// negotiateFormat picks the most acceptable of formats, ties are resolved by their order. Formats are picked only if
+// Accept names them explicitly, the requested format, "original" in the list, is matched by wildcards too.
+func negotiateFormat(accept helper.Accept, formats []string, requested string, origin *originInfo) string {
+	best, bestQuality := requested, 0.0
+	for _, format := range formats {
+		extension, wildcards := format, false
+		if format == config.FORMAT_ORIGINAL {
+			extension, wildcards = requested, true
+		}
+
+		quality := accept.Quality(mime.TypeByExtension("."+extension), wildcards)
+		if quality <= bestQuality || !supportsOutput(extension) {
+			continue
+		}
+		if extension != requested && loses(requested, extension, origin) {
+			continue
+		}
+
+		best, bestQuality = extension, quality
+	}
+	return best
+}
+
+// loses is true if frames or transparency of the origin kept by the requested format are lost by another one, origin
+// facts are read only if they could be lost
+func loses(requested, extension string, origin *originInfo) bool {
+	animation := supportsAnimation(requested) && !supportsAnimation(extension)
+	alpha := supportsAlpha(requested) && !supportsAlpha(extension)
+	if !animation && !alpha {
+		return false
+	}
+
+	info, err := origin.get()
+	if err != nil {
+		return false
+	}
+	return animation && info.Animated || alpha && info.Alpha
+}
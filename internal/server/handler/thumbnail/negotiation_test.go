@@ -0,0 +1,202 @@
+package thumbnail
+
+import (
+	"errors"
+	"github.com/urvin/gokaru/internal/contracts"
+	"github.com/urvin/gokaru/internal/server/helper"
+	"slices"
+	"testing"
+)
+
+// fakeCapabilities replaces format capabilities of libvips for a test
+func fakeCapabilities(t *testing.T) {
+	t.Helper()
+
+	output, animation, alpha := supportsOutput, supportsAnimation, supportsAlpha
+	t.Cleanup(func() {
+		supportsOutput, supportsAnimation, supportsAlpha = output, animation, alpha
+	})
+
+	supportsOutput = func(extension string) bool {
+		return slices.Contains([]string{"jpg", "png", "gif", "webp", "avif", "jxl"}, extension)
+	}
+	supportsAnimation = func(extension string) bool {
+		return slices.Contains([]string{"gif", "webp"}, extension)
+	}
+	supportsAlpha = func(extension string) bool {
+		return slices.Contains([]string{"png", "gif", "webp", "avif", "jxl"}, extension)
+	}
+}
+
+func stored(info contracts.OriginInfoDto, err error) *originInfo {
+	return &originInfo{read: true, info: info, err: err}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	fakeCapabilities(t)
+
+	photo := stored(contracts.OriginInfoDto{}, nil)
+	animated := stored(contracts.OriginInfoDto{Animated: true}, nil)
+	transparent := stored(contracts.OriginInfoDto{Alpha: true}, nil)
+
+	tests := []struct {
+		name      string
+		header    string
+		formats   []string
+		requested string
+		origin    *originInfo
+		format    string
+	}{
+		{
+			name:      "empty header keeps the requested format",
+			header:    "",
+			formats:   []string{"avif", "webp", "original"},
+			requested: "jpg",
+			origin:    photo,
+			format:    "jpg",
+		},
+		{
+			name:      "most preferred of accepted formats",
+			header:    "image/avif,image/webp,*/*",
+			formats:   []string{"avif", "webp", "original"},
+			requested: "jpg",
+			origin:    photo,
+			format:    "avif",
+		},
+		{
+			name:      "ties are resolved by the order of preference",
+			header:    "image/avif,image/webp,*/*",
+			formats:   []string{"webp", "avif", "original"},
+			requested: "jpg",
+			origin:    photo,
+			format:    "webp",
+		},
+		{
+			name:      "higher q-value wins over the order",
+			header:    "image/avif;q=0.5,image/webp;q=0.9,*/*;q=0.1",
+			formats:   []string{"avif", "webp", "original"},
+			requested: "jpg",
+			origin:    photo,
+			format:    "webp",
+		},
+		{
+			name:      "original wins a tie when listed first",
+			header:    "image/webp,image/jpeg",
+			formats:   []string{"original", "webp"},
+			requested: "jpg",
+			origin:    photo,
+			format:    "jpg",
+		},
+		{
+			name:      "q=0 excludes a format",
+			header:    "image/avif;q=0,image/webp,*/*",
+			formats:   []string{"avif", "webp", "original"},
+			requested: "jpg",
+			origin:    photo,
+			format:    "webp",
+		},
+		{
+			name:      "repeated ranges keep the most preferred",
+			header:    "image/webp;q=0.1,image/avif;q=0.5,image/webp;q=0.9",
+			formats:   []string{"avif", "webp", "original"},
+			requested: "jpg",
+			origin:    photo,
+			format:    "webp",
+		},
+		{
+			name:      "wildcards do not pick preferred formats",
+			header:    "image/*,*/*;q=0.8",
+			formats:   []string{"avif", "webp", "original"},
+			requested: "jpg",
+			origin:    photo,
+			format:    "jpg",
+		},
+		{
+			name:      "requested format is kept if nothing is acceptable",
+			header:    "text/html",
+			formats:   []string{"avif", "webp"},
+			requested: "jpg",
+			origin:    photo,
+			format:    "jpg",
+		},
+		{
+			name:      "formats without output support are skipped",
+			header:    "image/heic,image/webp",
+			formats:   []string{"heic", "webp"},
+			requested: "jpg",
+			origin:    photo,
+			format:    "webp",
+		},
+		{
+			name:      "animated origin is not moved to a static format",
+			header:    "image/avif,image/webp",
+			formats:   []string{"avif", "webp", "original"},
+			requested: "gif",
+			origin:    animated,
+			format:    "webp",
+		},
+		{
+			name:      "animated origin keeps the requested format",
+			header:    "image/avif",
+			formats:   []string{"avif", "original"},
+			requested: "gif",
+			origin:    animated,
+			format:    "gif",
+		},
+		{
+			name:      "static origin of an animated format is moved",
+			header:    "image/avif",
+			formats:   []string{"avif", "original"},
+			requested: "gif",
+			origin:    photo,
+			format:    "avif",
+		},
+		{
+			name:      "transparent origin is not moved to an opaque format",
+			header:    "image/jpeg,image/webp;q=0.5",
+			formats:   []string{"jpg", "webp", "original"},
+			requested: "png",
+			origin:    transparent,
+			format:    "webp",
+		},
+		{
+			name:      "opaque origin is moved to an opaque format",
+			header:    "image/jpeg,image/webp;q=0.5",
+			formats:   []string{"jpg", "webp", "original"},
+			requested: "png",
+			origin:    photo,
+			format:    "jpg",
+		},
+		{
+			name:      "unreadable origin is not kept back",
+			header:    "image/jpeg",
+			formats:   []string{"jpg", "original"},
+			requested: "png",
+			origin:    stored(contracts.OriginInfoDto{}, errors.New("not found")),
+			format:    "jpg",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format := negotiateFormat(helper.ParseAccept(test.header), test.formats, test.requested, test.origin)
+			if format != test.format {
+				t.Fatalf("expected %s, got %s", test.format, format)
+			}
+		})
+	}
+}
+
+func TestNegotiateFormatReadsOriginOnDemand(t *testing.T) {
+	fakeCapabilities(t)
+
+	// reading origin facts would fail without services, formats keeping everything of jpg do not need it
+	origin := &originInfo{}
+	format := negotiateFormat(helper.ParseAccept("image/webp"), []string{"webp", "original"}, "jpg", origin)
+	if format != "webp" {
+		t.Fatalf("expected webp, got %s", format)
+	}
+	if origin.read {
+		t.Fatal("origin facts are read")
+	}
+}
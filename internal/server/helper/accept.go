@@ -0,0 +1,61 @@
+package helper
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Accept holds media ranges of an Accept header with their q-values
+type Accept map[string]float64
+
+// ParseAccept parses an Accept header, media ranges without q-value get 1
+func ParseAccept(header string) Accept {
+	accept := make(Accept)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaRange == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = min(max(parsed, 0), 1)
+			}
+		}
+
+		// the most preferred of repeated ranges wins
+		if current, ok := accept[mediaRange]; !ok || q > current {
+			accept[mediaRange] = q
+		}
+	}
+	return accept
+}
+
+// Quality returns the q-value of a mime type, 0 if it is not acceptable. Wildcard ranges are taken into account only
+// if wildcards is true, browsers send them for types they could not decode. An empty header accepts anything.
+func (a Accept) Quality(mime string, wildcards bool) float64 {
+	if q, ok := a[mime]; ok {
+		return q
+	}
+	if !wildcards {
+		return 0
+	}
+	if len(a) == 0 {
+		return 1
+	}
+	if major, _, found := strings.Cut(mime, "/"); found {
+		if q, ok := a[major+"/*"]; ok {
+			return q
+		}
+	}
+	if q, ok := a["*/*"]; ok {
+		return q
+	}
+	return 0
+}
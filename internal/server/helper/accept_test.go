@@ -0,0 +1,94 @@
+package helper
+
+import "testing"
+
+func TestParseAccept(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		accept Accept
+	}{
+		{
+			name:   "empty header",
+			header: "",
+			accept: Accept{},
+		},
+		{
+			name:   "ranges without q-value",
+			header: "image/avif,image/webp",
+			accept: Accept{"image/avif": 1, "image/webp": 1},
+		},
+		{
+			name:   "q-values and params",
+			header: "image/webp;q=0.8, image/*; level=1; Q=0.5 ,*/*;q=0.1",
+			accept: Accept{"image/webp": 0.8, "image/*": 0.5, "*/*": 0.1},
+		},
+		{
+			name:   "case and spaces",
+			header: " Image/WebP ; q = 0.7 ",
+			accept: Accept{"image/webp": 0.7},
+		},
+		{
+			name:   "q=0 is kept",
+			header: "image/webp;q=0,image/png",
+			accept: Accept{"image/webp": 0, "image/png": 1},
+		},
+		{
+			name:   "repeated ranges keep the most preferred",
+			header: "image/webp;q=0.2,image/webp;q=0.9,image/webp;q=0",
+			accept: Accept{"image/webp": 0.9},
+		},
+		{
+			name:   "out of range and invalid q-values",
+			header: "image/webp;q=2,image/png;q=-1,image/gif;q=x",
+			accept: Accept{"image/webp": 1, "image/png": 0, "image/gif": 1},
+		},
+		{
+			name:   "empty ranges are skipped",
+			header: ",;q=0.5,image/png,",
+			accept: Accept{"image/png": 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accept := ParseAccept(test.header)
+			if len(accept) != len(test.accept) {
+				t.Fatalf("expected %v, got %v", test.accept, accept)
+			}
+			for mediaRange, q := range test.accept {
+				if parsed, ok := accept[mediaRange]; !ok || parsed != q {
+					t.Fatalf("expected %v, got %v", test.accept, accept)
+				}
+			}
+		})
+	}
+}
+
+func TestAcceptQuality(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		mime      string
+		wildcards bool
+		quality   float64
+	}{
+		{name: "exact range", header: "image/webp;q=0.8,*/*", mime: "image/webp", quality: 0.8},
+		{name: "exact range with wildcards", header: "image/webp;q=0.8,*/*", mime: "image/webp", wildcards: true, quality: 0.8},
+		{name: "excluded by q=0", header: "image/webp;q=0,image/*", mime: "image/webp", wildcards: true, quality: 0},
+		{name: "missing range", header: "image/png", mime: "image/webp", wildcards: true, quality: 0},
+		{name: "image wildcard ignored", header: "image/*", mime: "image/webp", quality: 0},
+		{name: "image wildcard", header: "image/*;q=0.6,*/*;q=0.1", mime: "image/webp", wildcards: true, quality: 0.6},
+		{name: "any wildcard", header: "text/*,*/*;q=0.1", mime: "image/webp", wildcards: true, quality: 0.1},
+		{name: "empty header without wildcards", header: "", mime: "image/webp", quality: 0},
+		{name: "empty header with wildcards", header: "", mime: "image/webp", wildcards: true, quality: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if quality := ParseAccept(test.header).Quality(test.mime, test.wildcards); quality != test.quality {
+				t.Fatalf("expected %v, got %v", test.quality, quality)
+			}
+		})
+	}
+}
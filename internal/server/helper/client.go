@@ -0,0 +1,50 @@
+package helper
+
+import (
+	"context"
+	"github.com/valyala/fasthttp"
+	"net"
+	"time"
+)
+
+// CLIENT_POLL_INTERVAL is the period of checks whether a client waiting for a response is still connected
+const CLIENT_POLL_INTERVAL = 250 * time.Millisecond
+
+// ClientContext returns a context of a request which is not cancelled on server shutdown, so that requests in progress
+// could finish, but is cancelled when the client disconnects or the timeout passes, zero timeout is unlimited
+func ClientContext(request *fasthttp.RequestCtx, timeout time.Duration) (ctx context.Context, cancel context.CancelFunc) {
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	conn := request.Conn()
+	go func() {
+		ticker := time.NewTicker(CLIENT_POLL_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if clientGone(conn) {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return
+}
+
+// rawConn unwraps TLS connections to the underlying one
+func rawConn(conn net.Conn) net.Conn {
+	for {
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return conn
+		}
+		conn = wrapper.NetConn()
+	}
+}
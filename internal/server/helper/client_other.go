@@ -0,0 +1,10 @@
+//go:build !unix
+
+package helper
+
+import "net"
+
+// clientGone could not detect disconnected clients on this platform, requests are limited by their timeout only
+func clientGone(conn net.Conn) bool {
+	return false
+}
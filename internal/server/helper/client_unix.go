@@ -0,0 +1,36 @@
+//go:build unix
+
+package helper
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// clientGone peeks at a connection without blocking, a closed connection reads nothing and a reset one fails,
+// pipelined requests are left in place
+func clientGone(conn net.Conn) bool {
+	sc, ok := rawConn(conn).(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	gone := false
+	buffer := make([]byte, 1)
+	err = raw.Control(func(fd uintptr) {
+		n, _, er := syscall.Recvfrom(int(fd), buffer, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		switch {
+		case er == nil:
+			gone = n == 0
+		case errors.Is(er, syscall.EAGAIN), errors.Is(er, syscall.EWOULDBLOCK), errors.Is(er, syscall.EINTR):
+		default:
+			gone = true
+		}
+	})
+	return err == nil && gone
+}
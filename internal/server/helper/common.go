@@ -3,21 +3,48 @@ package helper
 import (
 	"encoding/json"
 	"errors"
+	"github.com/urvin/gokaru/internal/config"
 	"github.com/urvin/gokaru/internal/contracts"
 	"github.com/urvin/gokaru/internal/helper"
 	"github.com/valyala/fasthttp"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const TIME_FORMAT = "Mon, 02 Jan 2006 15:04:05 GMT"
+const PRESET_FORMAT_DEFAULT = "jpg"
 
-func WriteJsonContent(context *fasthttp.RequestCtx, model interface{}) (err error) {
+// query args overriding trim settings of a thumbnail
+const (
+	TRIM_THRESHOLD_ARG = "trim_threshold"
+	TRIM_COLOR_ARG     = "trim_color"
+	TRIM_PADDING_ARG   = "trim_padding"
+)
+
+const TRIM_THRESHOLD_MAX = 255
+
+// query args overriding colour profile and metadata kept in a thumbnail
+const (
+	PROFILE_ARG  = "profile"
+	METADATA_ARG = "metadata"
+)
+
+// HEADER_QUALITY reports quality chosen by a target similarity search
+const HEADER_QUALITY = "X-Gokaru-Quality"
+
+var trimColorPattern = regexp.MustCompile("^[0-9a-f]{6}$")
+
+func WriteJsonContent(context *fasthttp.RequestCtx, statusCode int, model interface{}) (err error) {
 	content, err := json.Marshal(model)
 	if err != nil {
 		return
 	}
 
-	context.SetStatusCode(fasthttp.StatusCreated)
+	context.SetStatusCode(statusCode)
 	context.SetContentType("application/json; charset=utf-8")
 	context.SetBody(content)
 
@@ -77,9 +104,172 @@ func GetMiniatureInfoFromContext(context *fasthttp.RequestCtx) (miniature *contr
 	if len(miniature.Extension) == 0 {
 		err = errors.New("extension is empty")
 	}
+	if err != nil {
+		return
+	}
+
+	miniature.Trim, err = getTrimFromContext(context)
+	if err != nil {
+		return
+	}
+
+	miniature.Preserve, err = getPreserveFromContext(context)
+	return
+}
+
+// getTrimFromContext reads trim overrides from query args
+func getTrimFromContext(context *fasthttp.RequestCtx) (trim contracts.TrimDto, err error) {
+	args := context.QueryArgs()
+
+	if args.Has(TRIM_THRESHOLD_ARG) {
+		threshold, er := strconv.ParseFloat(string(args.Peek(TRIM_THRESHOLD_ARG)), 64)
+		if er != nil || threshold < 0 || threshold > TRIM_THRESHOLD_MAX {
+			err = errors.New("trim threshold should be a number from 0 to " + strconv.Itoa(TRIM_THRESHOLD_MAX))
+			return
+		}
+		trim.Threshold = &threshold
+	}
+
+	trim.Color = strings.ToLower(string(args.Peek(TRIM_COLOR_ARG)))
+	if trim.Color != "" && trim.Color != contracts.TRIM_COLOR_AUTO && !trimColorPattern.MatchString(trim.Color) {
+		err = errors.New("trim color should be rrggbb hex or " + contracts.TRIM_COLOR_AUTO)
+		return
+	}
+
+	if args.Has(TRIM_PADDING_ARG) {
+		padding, er := strconv.Atoi(string(args.Peek(TRIM_PADDING_ARG)))
+		if er != nil || padding < 0 {
+			err = errors.New("trim padding should not be less than 0")
+			return
+		}
+		trim.Padding = &padding
+	}
+	return
+}
+
+// getPreserveFromContext reads colour profile and metadata overrides from query args
+func getPreserveFromContext(context *fasthttp.RequestCtx) (preserve contracts.PreserveDto, err error) {
+	args := context.QueryArgs()
+
+	preserve.Profile = strings.ToLower(string(args.Peek(PROFILE_ARG)))
+	if preserve.Profile != "" && !slices.Contains(contracts.PROFILES, preserve.Profile) {
+		err = errors.New("profile should be one of " + strings.Join(contracts.PROFILES, ", "))
+		return
+	}
+
+	if !args.Has(METADATA_ARG) {
+		return
+	}
+	var metadata []string
+	for _, name := range strings.Split(strings.ToLower(string(args.Peek(METADATA_ARG))), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == contracts.METADATA_NONE {
+			continue
+		}
+		if !slices.Contains(contracts.METADATA, name) {
+			err = errors.New("metadata should be a list of " + strings.Join(contracts.METADATA, ", ") + " or " + contracts.METADATA_NONE)
+			return
+		}
+		if !slices.Contains(metadata, name) {
+			metadata = append(metadata, name)
+		}
+	}
+	// equal lists share thumbnails
+	slices.Sort(metadata)
+	preserve.Metadata = strings.Join(metadata, ",")
+	if preserve.Metadata == "" {
+		preserve.Metadata = contracts.METADATA_NONE
+	}
 	return
 }
 
+// GetPresetMiniatureInfoFromContext resolves a preset route, the extension defaults to the preset format
+func GetPresetMiniatureInfoFromContext(context *fasthttp.RequestCtx) (miniature *contracts.MiniatureDto, err error) {
+	origin := &contracts.OriginDto{
+		Type:     context.UserValue("sourceType").(string),
+		Category: context.UserValue("category").(string),
+	}
+
+	filename := context.UserValue("filename").(string)
+	origin.Name = helper.FileNameWithoutExtension(filename)
+	extension := helper.FileNameExtension(filename)
+
+	preset, ok := config.Get().Preset(context.UserValue("preset").(string))
+	if !ok {
+		err = errors.New("unknown preset " + context.UserValue("preset").(string))
+		return
+	}
+
+	miniature = PresetMiniature(origin, preset, extension)
+
+	if len(miniature.Category) == 0 {
+		err = errors.New("category is empty")
+	}
+	if len(miniature.Name) == 0 {
+		err = errors.New("name is empty")
+	}
+	return
+}
+
+// PresetMiniature describes a preset thumbnail of an origin, empty extension means the preset format
+func PresetMiniature(origin *contracts.OriginDto, preset config.Preset, extension string) *contracts.MiniatureDto {
+	if extension == "" {
+		extension = preset.Format
+	}
+	if extension == "" {
+		extension = PRESET_FORMAT_DEFAULT
+	}
+	return &contracts.MiniatureDto{
+		Type:      origin.Type,
+		Category:  origin.Category,
+		Name:      origin.Name,
+		Extension: extension,
+		Width:     preset.Width,
+		Height:    preset.Height,
+		Cast:      preset.Cast,
+		Preset:    preset.Name,
+	}
+}
+
+// ThumbnailUrl builds a thumbnail request path, see thumbnail handler routes
+func ThumbnailUrl(miniature *contracts.MiniatureDto, signature string) string {
+	if miniature.Preset != "" {
+		return "/" + miniature.Type + "/" +
+			signature + "/" +
+			miniature.Category + "/" +
+			miniature.Preset + "/" +
+			miniature.Name + "." + miniature.Extension
+	}
+	path := "/" + miniature.Type + "/" +
+		signature + "/" +
+		miniature.Category + "/" +
+		strconv.Itoa(miniature.Width) + "/" +
+		strconv.Itoa(miniature.Height) + "/" +
+		strconv.Itoa(miniature.Cast) + "/" +
+		miniature.Name + "." + miniature.Extension
+
+	query := url.Values{}
+	if miniature.Trim.Threshold != nil {
+		query.Set(TRIM_THRESHOLD_ARG, strconv.FormatFloat(*miniature.Trim.Threshold, 'f', -1, 64))
+	}
+	if miniature.Trim.Color != "" {
+		query.Set(TRIM_COLOR_ARG, miniature.Trim.Color)
+	}
+	if miniature.Trim.Padding != nil {
+		query.Set(TRIM_PADDING_ARG, strconv.Itoa(*miniature.Trim.Padding))
+	}
+	if miniature.Preserve.Profile != "" {
+		query.Set(PROFILE_ARG, miniature.Preserve.Profile)
+	}
+	if miniature.Preserve.Metadata != "" {
+		query.Set(METADATA_ARG, miniature.Preserve.Metadata)
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path
+}
+
 func ServeFile(context *fasthttp.RequestCtx, info contracts.FileDto) (err error) {
 
 	if !context.IfModifiedSince(info.ModificationTime) {
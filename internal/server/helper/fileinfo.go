@@ -1,12 +1,28 @@
 package helper
 
 import (
+	"bytes"
 	"mime"
 	"net/http"
 	"strings"
 )
 
+// JPEG XL codestream and container signatures, unknown to http.DetectContentType
+var jxlSignatures = [][]byte{
+	{0xff, 0x0a},
+	[]byte("\x00\x00\x00\x0cJXL \x0d\x0a\x87\x0a"),
+}
+
+func init() {
+	_ = mime.AddExtensionType(".jxl", "image/jxl")
+}
+
 func MimeByData(data []byte) string {
+	for _, signature := range jxlSignatures {
+		if bytes.HasPrefix(data, signature) {
+			return "image/jxl"
+		}
+	}
 	return http.DetectContentType(data)
 }
 
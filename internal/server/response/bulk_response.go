@@ -0,0 +1,11 @@
+package response
+
+type BulkFailureResponse struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+type BulkResponse struct {
+	Uploaded []UploadResponse      `json:"uploaded"`
+	Failed   []BulkFailureResponse `json:"failed"`
+}
@@ -0,0 +1,18 @@
+package response
+
+import "time"
+
+type CategoriesResponse struct {
+	Categories []string `json:"categories"`
+}
+
+type EntryResponse struct {
+	Name             string    `json:"name"`
+	Size             int64     `json:"size"`
+	ModificationTime time.Time `json:"modification_time"`
+}
+
+type ListResponse struct {
+	Files      []EntryResponse `json:"files"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
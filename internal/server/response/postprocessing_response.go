@@ -0,0 +1,14 @@
+package response
+
+import "time"
+
+type PostprocessingFailureResponse struct {
+	Thumbnail string    `json:"thumbnail"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+type PostprocessingFailuresResponse struct {
+	Failed []PostprocessingFailureResponse `json:"failed"`
+}
@@ -0,0 +1,16 @@
+package response
+
+type UploadResponse struct {
+	Type        string            `json:"type"`
+	Category    string            `json:"category"`
+	Name        string            `json:"name"`
+	Url         string            `json:"url"`
+	Size        int               `json:"size"`
+	ContentType string            `json:"content_type"`
+	Width       int               `json:"width,omitempty"`
+	Height      int               `json:"height,omitempty"`
+	Thumbnails  map[string]string `json:"thumbnails,omitempty"`
+	Warmup      string            `json:"warmup,omitempty"`
+	// RemovedMetadata lists kinds of private metadata stripped of the origin
+	RemovedMetadata []string `json:"removed_metadata,omitempty"`
+}
@@ -0,0 +1,13 @@
+package response
+
+import "time"
+
+type VersionResponse struct {
+	Version          string    `json:"version"`
+	Size             int64     `json:"size"`
+	ModificationTime time.Time `json:"modification_time"`
+}
+
+type VersionsResponse struct {
+	Versions []VersionResponse `json:"versions"`
+}
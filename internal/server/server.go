@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"github.com/fasthttp/router"
 	"github.com/urvin/gokaru/internal/config"
 	"github.com/urvin/gokaru/internal/server/handler/service"
@@ -14,6 +15,7 @@ import (
 
 type Server interface {
 	ListenAndServe() error
+	Shutdown(ctx context.Context) error
 }
 
 type server struct {
@@ -21,25 +23,31 @@ type server struct {
 	port    int
 	logger  *slog.Logger
 	router  *router.Router
+	srv     *fasthttp.Server
 }
 
 func NewServer(logger *slog.Logger) Server {
 	s := &server{}
 	s.logger = logger
 
-	return s
-}
-
-func (s *server) ListenAndServe() error {
 	s.initRouter()
 
-	srv := &fasthttp.Server{
+	s.srv = &fasthttp.Server{
 		Name:               "Gokaru v" + version.Version,
 		Handler:            s.router.Handler,
 		MaxRequestBodySize: config.Get().MaxUploadSize * 1024 * 1024,
 	}
 
-	return srv.ListenAndServe(":" + strconv.Itoa(config.Get().Port))
+	return s
+}
+
+func (s *server) ListenAndServe() error {
+	return s.srv.ListenAndServe(":" + strconv.Itoa(config.Get().Port))
+}
+
+// Shutdown stops accepting connections and waits for requests in progress until ctx is done
+func (s *server) Shutdown(ctx context.Context) error {
+	return s.srv.ShutdownWithContext(ctx)
 }
 
 func (s *server) initRouter() {
@@ -3,7 +3,8 @@ package storage
 import (
 	"crypto/md5"
 	"encoding/hex"
-	"github.com/n-marshall/go-cp"
+	"encoding/json"
+	"github.com/urvin/gokaru/internal/config"
 	"github.com/urvin/gokaru/internal/contracts"
 	"github.com/urvin/gokaru/internal/server/helper"
 	"io/ioutil"
@@ -15,9 +16,17 @@ import (
 
 const IMAGE_ORIGIN_PATH = "origin"
 const IMAGE_THUMBNAIL_PATH = "thumbnail"
+const PRESET_PATH_PREFIX = "preset-"
+
+// ORIGIN_INFO_PATH keeps origin facts among thumbnails, so that they are removed and relocated along with them
+const ORIGIN_INFO_PATH = "info"
+
+// QUALITY_SUFFIX is appended to a thumbnail file name for the file keeping the quality chosen for it
+const QUALITY_SUFFIX = ".quality"
 
 type fileStorage struct {
 	storagePath string
+	index       *index
 }
 
 func (fs *fileStorage) createPathIfNotExists(path string) (err error) {
@@ -61,18 +70,35 @@ func (fs *fileStorage) getOriginFilename(origin *contracts.OriginDto) string {
 }
 
 func (fs *fileStorage) getImageThumbnailFilename(miniature *contracts.MiniatureDto, del bool) string {
-	hashedFileName := fs.hashFileName(miniature.Name)
-	hashedFilePath := hashedFileName[0:2] + "/" + hashedFileName[2:4]
-
 	castPath := "*"
 	extensionPart := "*"
 	if !del {
 		castPath = strconv.Itoa(miniature.Width) + "x" + strconv.Itoa(miniature.Height) + "x" + strconv.Itoa(miniature.Cast)
+		if miniature.Preset != "" {
+			// thumbnails of changed preset settings are stored apart and rendered again
+			castPath = PRESET_PATH_PREFIX + miniature.Preset
+			if preset, ok := config.Get().Preset(miniature.Preset); ok {
+				castPath = PRESET_PATH_PREFIX + preset.Key()
+			}
+		}
+		if !miniature.Trim.IsZero() {
+			castPath += "-" + miniature.Trim.Key()
+		}
+		if !miniature.Preserve.IsZero() {
+			castPath += "-" + miniature.Preserve.Key()
+		}
 		extensionPart = miniature.Extension
 	}
 
-	result := fs.storagePath + "/" + miniature.Type + "/" + IMAGE_THUMBNAIL_PATH
-	result = result + "/" + miniature.Category + "/" + castPath + "/" + hashedFilePath + "/" + hashedFileName + "." + extensionPart
+	return fs.thumbnailFilename(miniature.Type, miniature.Category, castPath, miniature.Name, extensionPart)
+}
+
+func (fs *fileStorage) thumbnailFilename(originType, category, castPath, name, extension string) string {
+	hashedFileName := fs.hashFileName(name)
+	hashedFilePath := hashedFileName[0:2] + "/" + hashedFileName[2:4]
+
+	result := fs.storagePath + "/" + originType + "/" + IMAGE_THUMBNAIL_PATH
+	result = result + "/" + category + "/" + castPath + "/" + hashedFilePath + "/" + hashedFileName + "." + extension
 
 	return result
 }
@@ -86,57 +112,99 @@ func (fs *fileStorage) Write(origin *contracts.OriginDto, data []byte) (err erro
 	destinationFileName := fs.getOriginFilename(origin)
 	destinationPath := filepath.Dir(destinationFileName)
 
+	err = fs.archive(origin)
+	if err != nil {
+		return err
+	}
+
 	err = fs.createPathIfNotExists(destinationPath)
 	if err != nil {
 		return err
 	}
 
-	temporaryFile, err := ioutil.TempFile("", "upload")
+	err = fs.writeFile(destinationFileName, data)
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(temporaryFile.Name(), data, 0644)
+	err = fs.indexOrigin(origin)
 	if err != nil {
 		return err
 	}
 
+	if origin.Type == contracts.STORAGE_TYPE_IMAGE {
+		err = fs.RemoveThumbnails(origin)
+	}
+
+	return
+}
+
+// writeFile replaces a file with a rename, so readers never see partially written data
+func (fs *fileStorage) writeFile(fileName string, data []byte) (err error) {
+	temporaryFile, err := ioutil.TempFile(filepath.Dir(fileName), ".upload-*")
+	if err != nil {
+		return
+	}
 	defer func(name string) {
 		_ = os.Remove(name)
 	}(temporaryFile.Name())
 
-	err = cp.CopyFile(temporaryFile.Name(), destinationFileName)
+	_, err = temporaryFile.Write(data)
+	if er := temporaryFile.Close(); err == nil {
+		err = er
+	}
 	if err != nil {
-		return err
+		return
 	}
 
+	err = os.Chmod(temporaryFile.Name(), 0644)
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(temporaryFile.Name(), fileName)
 	return
 }
 
 func (fs *fileStorage) Remove(origin *contracts.OriginDto) (err error) {
+	err = fs.archive(origin)
+	if err != nil {
+		return
+	}
+
+	err = fs.index.delete(origin)
+	if err != nil {
+		return
+	}
+
 	originFileName := fs.getOriginFilename(origin)
 	defer func(name string) {
 		_ = os.Remove(name)
 	}(originFileName)
 
 	if origin.Type == "image" {
-		miniature := contracts.MiniatureDto{
-			Type:     origin.Type,
-			Category: origin.Category,
-			Name:     origin.Name,
-			Width:    0,
-			Height:   0,
-			Cast:     0,
-		}
-		thumbnailWildcard := fs.getImageThumbnailFilename(&miniature, true)
-		defer func(fs *fileStorage, wildcard string) {
-			_ = fs.removeByWildcard(wildcard)
-		}(fs, thumbnailWildcard)
+		defer func(fs *fileStorage, origin *contracts.OriginDto) {
+			_ = fs.RemoveThumbnails(origin)
+		}(fs, origin)
 	}
 
 	return
 }
 
+func (fs *fileStorage) RemoveThumbnails(origin *contracts.OriginDto) (err error) {
+	miniature := contracts.MiniatureDto{
+		Type:     origin.Type,
+		Category: origin.Category,
+		Name:     origin.Name,
+		Width:    0,
+		Height:   0,
+		Cast:     0,
+	}
+	thumbnailWildcard := fs.getImageThumbnailFilename(&miniature, true)
+	err = fs.removeByWildcard(thumbnailWildcard)
+	return
+}
+
 func (fs *fileStorage) getFileInfo(fileName string) (info contracts.FileDto, err error) {
 	file, err := os.Open(fileName)
 	if err != nil {
@@ -170,6 +238,12 @@ func (fs *fileStorage) Read(origin *contracts.OriginDto) (info contracts.FileDto
 	return
 }
 
+func (fs *fileStorage) Exists(origin *contracts.OriginDto) bool {
+	originFileName := fs.getOriginFilename(origin)
+	_, err := os.Stat(originFileName)
+	return !os.IsNotExist(err)
+}
+
 func (fs *fileStorage) ThumbnailExists(miniature *contracts.MiniatureDto) bool {
 	thumbnailFileName := fs.getImageThumbnailFilename(miniature, false)
 	_, err := os.Stat(thumbnailFileName)
@@ -179,10 +253,20 @@ func (fs *fileStorage) ThumbnailExists(miniature *contracts.MiniatureDto) bool {
 func (fs *fileStorage) ReadThumbnail(miniature *contracts.MiniatureDto) (info contracts.FileDto, err error) {
 	thumbnailFileName := fs.getImageThumbnailFilename(miniature, false)
 	info, err = fs.getFileInfo(thumbnailFileName)
+	if err != nil {
+		return
+	}
+
+	// thumbnails without a chosen quality have no quality file
+	if quality, er := ioutil.ReadFile(thumbnailFileName + QUALITY_SUFFIX); er == nil {
+		parsed, _ := strconv.ParseUint(string(quality), 10, 32)
+		info.Quality = uint(parsed)
+	}
 	return
 }
 
-func (fs *fileStorage) WriteThumbnail(miniature *contracts.MiniatureDto, data []byte) (err error) {
+// WriteThumbnail stores a thumbnail with the quality chosen for it, 0 if there is none
+func (fs *fileStorage) WriteThumbnail(miniature *contracts.MiniatureDto, data []byte, quality uint) (err error) {
 	thumbnailFileName := fs.getImageThumbnailFilename(miniature, false)
 	thumbnailPath := filepath.Dir(thumbnailFileName)
 
@@ -191,30 +275,76 @@ func (fs *fileStorage) WriteThumbnail(miniature *contracts.MiniatureDto, data []
 		return err
 	}
 
-	temporaryFile, err := ioutil.TempFile("", "upload")
+	// the quality goes first, so a written thumbnail is never read with the quality of the previous one
+	if quality > 0 {
+		err = fs.writeFile(thumbnailFileName+QUALITY_SUFFIX, []byte(strconv.FormatUint(uint64(quality), 10)))
+	} else if err = os.Remove(thumbnailFileName + QUALITY_SUFFIX); os.IsNotExist(err) {
+		err = nil
+	}
 	if err != nil {
-		return err
+		return
 	}
 
-	err = ioutil.WriteFile(temporaryFile.Name(), data, 0644)
+	err = fs.writeFile(thumbnailFileName, data)
+	return
+}
+
+func (fs *fileStorage) getOriginInfoFilename(origin *contracts.OriginDto) string {
+	return fs.thumbnailFilename(origin.Type, origin.Category, ORIGIN_INFO_PATH, origin.Name, "json")
+}
+
+// ReadOriginInfo returns stored facts of an origin, they are not found until written for its current data
+func (fs *fileStorage) ReadOriginInfo(origin *contracts.OriginDto) (info contracts.OriginInfoDto, err error) {
+	data, err := ioutil.ReadFile(fs.getOriginInfoFilename(origin))
 	if err != nil {
-		return err
+		return
 	}
+	err = json.Unmarshal(data, &info)
+	return
+}
 
-	defer func(name string) {
-		_ = os.Remove(name)
-	}(temporaryFile.Name())
+func (fs *fileStorage) WriteOriginInfo(origin *contracts.OriginDto, info contracts.OriginInfoDto) (err error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
 
-	err = cp.CopyFile(temporaryFile.Name(), thumbnailFileName)
+	infoFileName := fs.getOriginInfoFilename(origin)
+	err = fs.createPathIfNotExists(filepath.Dir(infoFileName))
 	if err != nil {
-		return err
+		return
 	}
 
+	err = fs.writeFile(infoFileName, data)
+	return
+}
+
+func (fs *fileStorage) Categories(originType string) (categories []string, err error) {
+	categories, err = fs.index.categories(originType)
+	return
+}
+
+func (fs *fileStorage) List(originType, category, prefix, cursor string, limit int) (entries []contracts.EntryDto, nextCursor string, err error) {
+	entries, nextCursor, err = fs.index.list(originType, category, prefix, cursor, limit)
 	return
 }
 
-func NewFileStorage(path string) Storage {
+func (fs *fileStorage) Close() error {
+	return fs.index.close()
+}
+
+func NewFileStorage(path string) (Storage, error) {
 	result := &fileStorage{}
 	result.SetStoragePath(path)
-	return result
+
+	err := result.createPathIfNotExists(result.storagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	result.index, err = newIndex(result.storagePath + "/" + INDEX_FILENAME)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"github.com/urvin/gokaru/internal/contracts"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// linkFile places a hard link of a file with a rename, falling back to copying contents
+func (fs *fileStorage) linkFile(source, destination string) (err error) {
+	temporaryFileName := filepath.Dir(destination) + "/.link-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	if os.Link(source, temporaryFileName) == nil {
+		err = os.Rename(temporaryFileName, destination)
+		if err != nil {
+			_ = os.Remove(temporaryFileName)
+		}
+		return
+	}
+
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return
+	}
+	err = fs.writeFile(destination, data)
+	return
+}
+
+func (fs *fileStorage) Copy(source, destination *contracts.OriginDto, thumbnails bool) (err error) {
+	sourceFileName := fs.getOriginFilename(source)
+	destinationFileName := fs.getOriginFilename(destination)
+
+	_, err = os.Stat(sourceFileName)
+	if err != nil {
+		return
+	}
+
+	err = fs.archive(destination)
+	if err != nil {
+		return
+	}
+
+	err = fs.createPathIfNotExists(filepath.Dir(destinationFileName))
+	if err != nil {
+		return
+	}
+
+	err = fs.linkFile(sourceFileName, destinationFileName)
+	if err != nil {
+		return
+	}
+
+	err = fs.indexOrigin(destination)
+	if err != nil {
+		return
+	}
+
+	if destination.Type == contracts.STORAGE_TYPE_IMAGE {
+		err = fs.RemoveThumbnails(destination)
+		if err == nil && thumbnails {
+			err = fs.relocateThumbnails(source, destination, false)
+		}
+	}
+	return
+}
+
+func (fs *fileStorage) Move(source, destination *contracts.OriginDto, thumbnails bool) (err error) {
+	sourceFileName := fs.getOriginFilename(source)
+	destinationFileName := fs.getOriginFilename(destination)
+
+	_, err = os.Stat(sourceFileName)
+	if err != nil {
+		return
+	}
+
+	err = fs.archive(destination)
+	if err != nil {
+		return
+	}
+
+	err = fs.createPathIfNotExists(filepath.Dir(destinationFileName))
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(sourceFileName, destinationFileName)
+	if err != nil {
+		return
+	}
+
+	err = fs.indexOrigin(destination)
+	if err != nil {
+		return
+	}
+	err = fs.index.delete(source)
+	if err != nil {
+		return
+	}
+
+	if destination.Type == contracts.STORAGE_TYPE_IMAGE {
+		err = fs.RemoveThumbnails(destination)
+		if err != nil {
+			return
+		}
+		if thumbnails {
+			err = fs.relocateThumbnails(source, destination, true)
+			if err != nil {
+				return
+			}
+		}
+		err = fs.RemoveThumbnails(source)
+		if err != nil {
+			return
+		}
+	}
+
+	err = fs.moveVersions(source, destination)
+	return
+}
+
+func (fs *fileStorage) indexOrigin(origin *contracts.OriginDto) (err error) {
+	stat, err := os.Stat(fs.getOriginFilename(origin))
+	if err != nil {
+		return
+	}
+	err = fs.index.put(origin, stat.Size(), stat.ModTime())
+	return
+}
+
+// relocateThumbnails links or moves every thumbnail of the source to the same variant of the destination
+func (fs *fileStorage) relocateThumbnails(source, destination *contracts.OriginDto, move bool) (err error) {
+	miniature := contracts.MiniatureDto{
+		Type:     source.Type,
+		Category: source.Category,
+		Name:     source.Name,
+	}
+	files, err := filepath.Glob(fs.getImageThumbnailFilename(&miniature, true))
+	if err != nil {
+		return
+	}
+
+	prefix := fs.hashFileName(source.Name) + "."
+	for _, file := range files {
+		// .../{castPath}/xx/yy/{hash}.{extension}, quality files keep their suffix in the extension
+		castPath := filepath.Base(filepath.Dir(filepath.Dir(filepath.Dir(file))))
+		extension := strings.TrimPrefix(filepath.Base(file), prefix)
+
+		target := fs.thumbnailFilename(destination.Type, destination.Category, castPath, destination.Name, extension)
+		err = fs.createPathIfNotExists(filepath.Dir(target))
+		if err != nil {
+			return
+		}
+
+		if move {
+			err = os.Rename(file, target)
+		} else {
+			err = fs.linkFile(file, target)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
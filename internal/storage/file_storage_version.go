@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"errors"
+	"github.com/urvin/gokaru/internal/config"
+	"github.com/urvin/gokaru/internal/contracts"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const VERSION_PATH = "version"
+
+var ErrInvalidVersion = errors.New("invalid version")
+
+func (fs *fileStorage) getVersionPath(origin *contracts.OriginDto) string {
+	hashedFileName := fs.hashFileName(origin.Name)
+	hashedFilePath := hashedFileName[0:2] + "/" + hashedFileName[2:4]
+
+	return fs.storagePath + "/" + VERSION_PATH + "/" + origin.Type + "/" + origin.Category + "/" + hashedFilePath + "/" + hashedFileName
+}
+
+func (fs *fileStorage) getVersionFilename(origin *contracts.OriginDto, version string) (fileName string, err error) {
+	if _, er := strconv.ParseInt(version, 10, 64); er != nil {
+		err = ErrInvalidVersion
+		return
+	}
+	fileName = fs.getVersionPath(origin) + "/" + version
+	return
+}
+
+// archive keeps a copy of the current origin as a version, if versioning is enabled for its category
+func (fs *fileStorage) archive(origin *contracts.OriginDto) (err error) {
+	if !config.Get().Category(origin.Category).Versioning.Enabled {
+		return
+	}
+
+	originFileName := fs.getOriginFilename(origin)
+	stat, err := os.Stat(originFileName)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	versionPath := fs.getVersionPath(origin)
+	err = fs.createPathIfNotExists(versionPath)
+	if err != nil {
+		return
+	}
+
+	// origins are replaced by rename, so a hard link keeps archived data intact
+	versionFileName := versionPath + "/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	if os.Link(originFileName, versionFileName) != nil {
+		data, er := ioutil.ReadFile(originFileName)
+		if er != nil {
+			err = er
+			return
+		}
+
+		err = ioutil.WriteFile(versionFileName, data, 0644)
+		if err != nil {
+			return
+		}
+
+		// a version keeps the time its data was uploaded
+		err = os.Chtimes(versionFileName, stat.ModTime(), stat.ModTime())
+		if err != nil {
+			return
+		}
+	}
+
+	err = fs.pruneVersions(origin)
+	return
+}
+
+func (fs *fileStorage) pruneVersions(origin *contracts.OriginDto) (err error) {
+	versioning := config.Get().Category(origin.Category).Versioning
+
+	versions, err := fs.listVersions(origin)
+	if err != nil {
+		return
+	}
+
+	versionPath := fs.getVersionPath(origin)
+	for i, version := range versions {
+		if expired(versioning, i, version) {
+			if er := os.Remove(versionPath + "/" + version.Id); er != nil && !os.IsNotExist(er) {
+				err = er
+				return
+			}
+		}
+	}
+	return
+}
+
+// expired is true if the i-th newest version is beyond the count or age limits
+func expired(versioning config.Versioning, i int, version contracts.VersionDto) bool {
+	if versioning.MaxCount > 0 && uint(i) >= versioning.MaxCount {
+		return true
+	}
+	if versioning.MaxAge > 0 {
+		archived, _ := strconv.ParseInt(version.Id, 10, 64)
+		return time.Since(time.Unix(0, archived)) > versioning.MaxAge
+	}
+	return false
+}
+
+// listVersions returns stored versions of an origin, newest first
+func (fs *fileStorage) listVersions(origin *contracts.OriginDto) (versions []contracts.VersionDto, err error) {
+	versions = []contracts.VersionDto{}
+
+	files, err := ioutil.ReadDir(fs.getVersionPath(origin))
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if _, er := strconv.ParseInt(file.Name(), 10, 64); er != nil || file.IsDir() {
+			continue
+		}
+		versions = append(versions, contracts.VersionDto{
+			Id:               file.Name(),
+			Size:             file.Size(),
+			ModificationTime: file.ModTime(),
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		a, _ := strconv.ParseInt(versions[i].Id, 10, 64)
+		b, _ := strconv.ParseInt(versions[j].Id, 10, 64)
+		return a > b
+	})
+
+	return
+}
+
+// moveVersions hands stored versions of a moved origin over to its new name
+func (fs *fileStorage) moveVersions(source, destination *contracts.OriginDto) (err error) {
+	versions, err := fs.listVersions(source)
+	if err != nil || len(versions) == 0 {
+		return
+	}
+
+	sourcePath := fs.getVersionPath(source)
+	destinationPath := fs.getVersionPath(destination)
+	err = fs.createPathIfNotExists(destinationPath)
+	if err != nil {
+		return
+	}
+
+	for _, version := range versions {
+		err = os.Rename(sourcePath+"/"+version.Id, destinationPath+"/"+version.Id)
+		if err != nil {
+			return
+		}
+	}
+	_ = os.Remove(sourcePath)
+
+	err = fs.pruneVersions(destination)
+	return
+}
+
+// Versions lists stored versions of an origin, newest first. Expired versions are left out, they are removed on the
+// next write or restore.
+func (fs *fileStorage) Versions(origin *contracts.OriginDto) (versions []contracts.VersionDto, err error) {
+	stored, err := fs.listVersions(origin)
+	if err != nil {
+		return
+	}
+
+	versioning := config.Get().Category(origin.Category).Versioning
+	versions = []contracts.VersionDto{}
+	for i, version := range stored {
+		if !expired(versioning, i, version) {
+			versions = append(versions, version)
+		}
+	}
+	return
+}
+
+// getStoredVersionFilename returns the file of a version listed by Versions, expired versions are not found
+func (fs *fileStorage) getStoredVersionFilename(origin *contracts.OriginDto, version string) (fileName string, err error) {
+	fileName, err = fs.getVersionFilename(origin, version)
+	if err != nil {
+		return
+	}
+
+	versions, err := fs.Versions(origin)
+	if err != nil {
+		return
+	}
+	for _, stored := range versions {
+		if stored.Id == version {
+			return
+		}
+	}
+	err = os.ErrNotExist
+	return
+}
+
+func (fs *fileStorage) ReadVersion(origin *contracts.OriginDto, version string) (info contracts.FileDto, err error) {
+	versionFileName, err := fs.getStoredVersionFilename(origin, version)
+	if err != nil {
+		return
+	}
+	info, err = fs.getFileInfo(versionFileName)
+	return
+}
+
+func (fs *fileStorage) RestoreVersion(origin *contracts.OriginDto, version string) (err error) {
+	versionFileName, err := fs.getStoredVersionFilename(origin, version)
+	if err != nil {
+		return
+	}
+
+	data, err := ioutil.ReadFile(versionFileName)
+	if err != nil {
+		return
+	}
+
+	err = fs.Write(origin, data)
+	if err != nil {
+		return
+	}
+
+	err = fs.pruneVersions(origin)
+	return
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/urvin/gokaru/internal/contracts"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+const INDEX_FILENAME = "index.db"
+
+// index keeps origin names, which are hashed on disk, in type and category buckets
+type index struct {
+	db *bolt.DB
+}
+
+func newIndex(fileName string) (idx *index, err error) {
+	db, err := bolt.Open(fileName, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return
+	}
+	idx = &index{db: db}
+	return
+}
+
+func (idx *index) encodeEntry(size int64, modificationTime time.Time) []byte {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value[0:8], uint64(size))
+	binary.BigEndian.PutUint64(value[8:16], uint64(modificationTime.UnixNano()))
+	return value
+}
+
+func (idx *index) decodeEntry(name, value []byte) (entry contracts.EntryDto) {
+	entry.Name = string(name)
+	if len(value) == 16 {
+		entry.Size = int64(binary.BigEndian.Uint64(value[0:8]))
+		entry.ModificationTime = time.Unix(0, int64(binary.BigEndian.Uint64(value[8:16])))
+	}
+	return
+}
+
+func (idx *index) put(origin *contracts.OriginDto, size int64, modificationTime time.Time) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		typeBucket, err := tx.CreateBucketIfNotExists([]byte(origin.Type))
+		if err != nil {
+			return err
+		}
+		categoryBucket, err := typeBucket.CreateBucketIfNotExists([]byte(origin.Category))
+		if err != nil {
+			return err
+		}
+		return categoryBucket.Put([]byte(origin.Name), idx.encodeEntry(size, modificationTime))
+	})
+}
+
+func (idx *index) delete(origin *contracts.OriginDto) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		typeBucket := tx.Bucket([]byte(origin.Type))
+		if typeBucket == nil {
+			return nil
+		}
+		categoryBucket := typeBucket.Bucket([]byte(origin.Category))
+		if categoryBucket == nil {
+			return nil
+		}
+		err := categoryBucket.Delete([]byte(origin.Name))
+		if err != nil {
+			return err
+		}
+
+		// drop emptied categories
+		if name, _ := categoryBucket.Cursor().First(); name == nil {
+			return typeBucket.DeleteBucket([]byte(origin.Category))
+		}
+		return nil
+	})
+}
+
+func (idx *index) categories(originType string) (categories []string, err error) {
+	categories = []string{}
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		typeBucket := tx.Bucket([]byte(originType))
+		if typeBucket == nil {
+			return nil
+		}
+		return typeBucket.ForEach(func(name, value []byte) error {
+			if value == nil {
+				categories = append(categories, string(name))
+			}
+			return nil
+		})
+	})
+	return
+}
+
+// list returns up to limit entries with the prefix, which names go after the cursor, and the cursor of the next page
+func (idx *index) list(originType, category, prefix, cursor string, limit int) (entries []contracts.EntryDto, nextCursor string, err error) {
+	entries = []contracts.EntryDto{}
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		typeBucket := tx.Bucket([]byte(originType))
+		if typeBucket == nil {
+			return nil
+		}
+		categoryBucket := typeBucket.Bucket([]byte(category))
+		if categoryBucket == nil {
+			return nil
+		}
+
+		c := categoryBucket.Cursor()
+
+		start := []byte(prefix)
+		if cursor > prefix {
+			start = []byte(cursor)
+		}
+
+		name, value := c.Seek(start)
+		if cursor != "" && bytes.Equal(name, []byte(cursor)) {
+			name, value = c.Next()
+		}
+
+		for ; name != nil && bytes.HasPrefix(name, []byte(prefix)); name, value = c.Next() {
+			if len(entries) == limit {
+				nextCursor = entries[len(entries)-1].Name
+				break
+			}
+			entries = append(entries, idx.decodeEntry(name, value))
+		}
+		return nil
+	})
+	return
+}
+
+func (idx *index) close() error {
+	return idx.db.Close()
+}
@@ -5,10 +5,26 @@ import "github.com/urvin/gokaru/internal/contracts"
 type Storage interface {
 	Write(origin *contracts.OriginDto, data []byte) (err error)
 
+	Exists(origin *contracts.OriginDto) bool
 	Remove(origin *contracts.OriginDto) (err error)
 	Read(origin *contracts.OriginDto) (info contracts.FileDto, err error)
+	Copy(source, destination *contracts.OriginDto, thumbnails bool) (err error)
+	Move(source, destination *contracts.OriginDto, thumbnails bool) (err error)
+
+	Versions(origin *contracts.OriginDto) (versions []contracts.VersionDto, err error)
+	ReadVersion(origin *contracts.OriginDto, version string) (info contracts.FileDto, err error)
+	RestoreVersion(origin *contracts.OriginDto, version string) (err error)
+
+	Categories(originType string) (categories []string, err error)
+	List(originType, category, prefix, cursor string, limit int) (entries []contracts.EntryDto, nextCursor string, err error)
 
 	ThumbnailExists(miniature *contracts.MiniatureDto) bool
 	ReadThumbnail(miniature *contracts.MiniatureDto) (info contracts.FileDto, err error)
-	WriteThumbnail(miniature *contracts.MiniatureDto, data []byte) (err error)
+	WriteThumbnail(miniature *contracts.MiniatureDto, data []byte, quality uint) (err error)
+	RemoveThumbnails(origin *contracts.OriginDto) (err error)
+
+	ReadOriginInfo(origin *contracts.OriginDto) (info contracts.OriginInfoDto, err error)
+	WriteOriginInfo(origin *contracts.OriginDto, info contracts.OriginInfoDto) (err error)
+
+	Close() error
 }
@@ -0,0 +1,17 @@
+package thumbnailer
+
+// EXTENSION_AUTO lets Gokaru pick a thumbnail format by origin content and client
+const EXTENSION_AUTO = "auto"
+
+// AutoFormat picks a thumbnail format of an origin any client could decode: animations to gif, indexed colour
+// graphics and images with alpha to png, photos to jpg
+func AutoFormat(info ImageInfo) string {
+	switch {
+	case info.Animated:
+		return "gif"
+	case info.Palette || info.Alpha:
+		return "png"
+	default:
+		return "jpg"
+	}
+}
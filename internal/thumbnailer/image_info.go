@@ -0,0 +1,13 @@
+package thumbnailer
+
+import "github.com/urvin/gokaru/internal/vips"
+
+type ImageInfo struct {
+	ImageType vips.ImageType
+	Width     int
+	Height    int
+	Animated  bool
+	Alpha     bool
+	// Palette is true for indexed colour origins, flat graphics usually
+	Palette bool
+}
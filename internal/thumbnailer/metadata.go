@@ -0,0 +1,153 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/urvin/gokaru/internal/contracts"
+	"github.com/urvin/gokaru/internal/vips"
+	"slices"
+)
+
+const XMP_FIELD = "xmp-data"
+
+// EXIF_FIELDS are libvips fields of metadata kept in thumbnails, libvips rebuilds EXIF of them on save
+var EXIF_FIELDS = map[string]string{
+	contracts.METADATA_COPYRIGHT: "exif-ifd0-Copyright",
+	contracts.METADATA_ARTIST:    "exif-ifd0-Artist",
+}
+
+const (
+	XMP_RDF_NS        = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	XMP_DC_NS         = "http://purl.org/dc/elements/1.1/"
+	XMP_RIGHTS_NS     = "http://ns.adobe.com/xap/1.0/rights/"
+	XMP_PACKET_HEADER = "<?xpacket begin=\"\uFEFF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>"
+	XMP_PACKET_FOOTER = "<?xpacket end=\"w\"?>"
+)
+
+// XMP_DC_RIGHTS are Dublin Core properties kept as rights
+var XMP_DC_RIGHTS = []string{"rights", "creator"}
+
+// convertProfile converts a frame to sRGB and removes its profile, unless a wide colour profile is kept or
+// converted to Display P3
+func convertProfile(image *vips.Image, profile string) error {
+	if image.HasWideColourProfile() {
+		switch profile {
+		case contracts.PROFILE_KEEP:
+			return nil
+		case contracts.PROFILE_P3:
+			return image.TransformColourProfileToP3()
+		}
+	}
+
+	if err := image.TransformColourProfile(); err != nil {
+		return err
+	}
+	return image.RemoveColourProfile()
+}
+
+// stripMetadata removes metadata of a frame except the listed one, XMP is reduced to rights
+func stripMetadata(image *vips.Image, metadata []string) error {
+	var keep []string
+	for _, name := range metadata {
+		if field, ok := EXIF_FIELDS[name]; ok {
+			keep = append(keep, field)
+		}
+	}
+
+	var xmp []byte
+	if slices.Contains(metadata, contracts.METADATA_XMP_RIGHTS) {
+		xmp = xmpRights(image.GetBlob(XMP_FIELD))
+	}
+
+	if err := image.StripExcept(keep...); err != nil {
+		return err
+	}
+	image.SetBlob(XMP_FIELD, xmp)
+	return nil
+}
+
+// xmpRights builds an XMP packet of rights properties of a packet, nil if there are none
+func xmpRights(packet []byte) []byte {
+	if len(packet) == 0 {
+		return nil
+	}
+
+	var attributes []xml.Attr
+	var properties bytes.Buffer
+	encoder := xml.NewEncoder(&properties)
+
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	// depth of the current element below rdf:Description, 0 outside of descriptions
+	depth := 0
+	// depth of a copied property, 0 if none is copied
+	copied := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				if t.Name.Space == XMP_RDF_NS && t.Name.Local == "Description" {
+					depth = 1
+					for _, attr := range t.Attr {
+						if isXmpRight(attr.Name) {
+							attributes = append(attributes, attr)
+						}
+					}
+				}
+				continue
+			}
+			depth++
+			if copied == 0 && depth == 2 && isXmpRight(t.Name) {
+				copied = depth
+			}
+			if copied > 0 {
+				_ = encoder.EncodeToken(t.Copy())
+			}
+		case xml.EndElement:
+			if depth == 0 {
+				continue
+			}
+			if copied > 0 {
+				_ = encoder.EncodeToken(t)
+				if depth == copied {
+					copied = 0
+				}
+			}
+			depth--
+		case xml.CharData:
+			if copied > 0 {
+				_ = encoder.EncodeToken(t.Copy())
+			}
+		}
+	}
+	if encoder.Flush() != nil || len(attributes) == 0 && properties.Len() == 0 {
+		return nil
+	}
+
+	var result bytes.Buffer
+	result.WriteString(XMP_PACKET_HEADER)
+	result.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="` + XMP_RDF_NS + `">`)
+	result.WriteString(`<rdf:Description rdf:about="" xmlns:dc="` + XMP_DC_NS + `" xmlns:xmpRights="` + XMP_RIGHTS_NS + `"`)
+	for _, attr := range attributes {
+		prefix := "xmpRights:"
+		if attr.Name.Space == XMP_DC_NS {
+			prefix = "dc:"
+		}
+		result.WriteString(" " + prefix + attr.Name.Local + `="`)
+		_ = xml.EscapeText(&result, []byte(attr.Value))
+		result.WriteString(`"`)
+	}
+	result.WriteString(">")
+	result.Write(properties.Bytes())
+	result.WriteString("</rdf:Description></rdf:RDF></x:xmpmeta>")
+	result.WriteString(XMP_PACKET_FOOTER)
+	return result.Bytes()
+}
+
+func isXmpRight(name xml.Name) bool {
+	return name.Space == XMP_RIGHTS_NS || name.Space == XMP_DC_NS && slices.Contains(XMP_DC_RIGHTS, name.Local)
+}
@@ -0,0 +1,203 @@
+package thumbnailer
+
+import (
+	"context"
+	"errors"
+	"github.com/urvin/gokaru/internal/config"
+	"github.com/urvin/gokaru/internal/helper"
+	"github.com/urvin/gokaru/internal/metrics"
+	"github.com/urvin/gokaru/internal/vips"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const POSTPROCESS_STEP_TIMEOUT_DEFAULT = time.Minute
+
+// placeholders of post-processing step args
+const (
+	STEP_ARG_INPUT      = "{input}"
+	STEP_ARG_OUTPUT     = "{output}"
+	STEP_ARG_QUALITY    = "{quality}"
+	STEP_ARG_ITERATIONS = "{iterations}"
+)
+
+const (
+	STEP_STATUS_APPLIED   = "applied"
+	STEP_STATUS_DISCARDED = "discarded"
+	STEP_STATUS_SKIPPED   = "skipped"
+	STEP_STATUS_MISSING   = "missing"
+	STEP_STATUS_FAILED    = "failed"
+)
+
+// defaultPipelines are used when config.yml has no postprocessing section at all
+var defaultPipelines = []config.Pipeline{
+	{
+		Format: "png",
+		Steps: []config.Step{
+			{
+				Name:          "zopflipng",
+				Command:       "zopflipng",
+				Args:          []string{"--iterations=" + STEP_ARG_ITERATIONS, "-y", "--filters=01234mepb", "--lossy_8bit", "--lossy_transparent", STEP_ARG_INPUT, STEP_ARG_OUTPUT},
+				KeepIfSmaller: true,
+			},
+		},
+	},
+}
+
+// Later returns post-processing of thumbnails of the options, nil if the format has no pipeline
+func (t *thumbnailer) Later(options ThumbnailOptions) func([]byte) ([]byte, error) {
+	pipeline, ok := t.pipeline(options.ImageType())
+	if !ok {
+		return nil
+	}
+	return func(data []byte) ([]byte, error) {
+		return t.postprocess(pipeline, options.ImageType(), options.Category(), data)
+	}
+}
+
+func (t *thumbnailer) pipeline(imageType vips.ImageType) (config.Pipeline, bool) {
+	pipelines := config.Get().Postprocessing
+	if pipelines == nil {
+		pipelines = defaultPipelines
+	}
+	for _, pipeline := range pipelines {
+		if vips.ImageTypes[pipeline.Format] == imageType && len(pipeline.Steps) > 0 {
+			return pipeline, true
+		}
+	}
+	return config.Pipeline{}, false
+}
+
+// postprocess runs steps of a pipeline one by one, each one gets the result of the last applied one
+func (t *thumbnailer) postprocess(pipeline config.Pipeline, imageType vips.ImageType, category string, data []byte) (result []byte, err error) {
+	dir, err := os.MkdirTemp("", "thumbnail-postprocess")
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	info, err := t.Inspect(data)
+	if err != nil {
+		return
+	}
+	q := t.getQuality(uint(info.Width), uint(info.Height), imageType, category)
+
+	replacer := strings.NewReplacer(
+		STEP_ARG_INPUT, filepath.Join(dir, "input."+pipeline.Format),
+		STEP_ARG_OUTPUT, filepath.Join(dir, "output."+pipeline.Format),
+		STEP_ARG_QUALITY, strconv.FormatUint(uint64(q.Quality), 10),
+		STEP_ARG_ITERATIONS, strconv.FormatUint(uint64(q.Iterations), 10),
+	)
+
+	result = data
+	for _, step := range pipeline.Steps {
+		name := step.Name
+		if name == "" {
+			name = step.Command
+		}
+
+		start := time.Now()
+		processed, status, er := t.runStep(step, q, replacer, dir, pipeline.Format, result)
+		metrics.Add("gokaru_postprocess_step_total", 1, "format", pipeline.Format, "step", name, "status", status)
+		metrics.Add("gokaru_postprocess_step_seconds_total", time.Since(start).Seconds(), "format", pipeline.Format, "step", name)
+		if er != nil {
+			err = errors.New(name + ": " + er.Error())
+			return
+		}
+		if status != STEP_STATUS_APPLIED {
+			continue
+		}
+
+		metrics.Add("gokaru_postprocess_step_saved_bytes_total", float64(len(result)-len(processed)), "format", pipeline.Format, "step", name)
+		t.logger.Info(
+			"Post-processing step "+name+" made "+strconv.Itoa(len(result))+" bytes "+strconv.Itoa(len(processed)),
+			"context", "thumbnailer",
+			"format", pipeline.Format,
+		)
+		result = processed
+	}
+	return
+}
+
+// runStep runs a step tool over input file, the result is read from output file if the step has one, otherwise
+// the tool is expected to change input file in place. Errors are reserved for temporary files, a failing tool
+// just fails the step.
+func (t *thumbnailer) runStep(step config.Step, q quality, replacer *strings.Replacer, dir, format string, data []byte) (result []byte, status string, err error) {
+	if q.Iterations == 0 && slices.ContainsFunc(step.Args, func(arg string) bool {
+		return strings.Contains(arg, STEP_ARG_ITERATIONS)
+	}) {
+		status = STEP_STATUS_SKIPPED
+		return
+	}
+
+	if _, er := exec.LookPath(step.Command); er != nil {
+		if _, reported := t.missing.LoadOrStore(step.Command, true); !reported {
+			t.logger.Warn(
+				"Post-processing tool "+step.Command+" is missing, skipping",
+				"context", "thumbnailer",
+				"format", format,
+				"error", er.Error(),
+			)
+		}
+		status = STEP_STATUS_MISSING
+		return
+	}
+
+	input := filepath.Join(dir, "input."+format)
+	output := filepath.Join(dir, "output."+format)
+	_ = os.Remove(output)
+	err = os.WriteFile(input, data, 0644)
+	if err != nil {
+		status = STEP_STATUS_FAILED
+		return
+	}
+
+	args := make([]string, 0, len(step.Args))
+	resultFile := input
+	for _, arg := range step.Args {
+		if strings.Contains(arg, STEP_ARG_OUTPUT) {
+			resultFile = output
+		}
+		args = append(args, replacer.Replace(arg))
+	}
+
+	timeout := step.Timeout
+	if timeout == 0 {
+		timeout = POSTPROCESS_STEP_TIMEOUT_DEFAULT
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// failing tools, e.g. pngquant refusing too low quality, are skipped like discarded results
+	_, er := helper.ExecContext(ctx, step.Command, args...)
+	if er == nil {
+		result, er = os.ReadFile(resultFile)
+	}
+	if er == nil && len(result) == 0 {
+		er = errors.New("empty result")
+	}
+	if er != nil {
+		t.logger.Warn(
+			"Post-processing step "+step.Command+" failed, skipping",
+			"context", "thumbnailer",
+			"format", format,
+			"error", er.Error(),
+		)
+		result = nil
+		status = STEP_STATUS_FAILED
+		return
+	}
+
+	status = STEP_STATUS_APPLIED
+	if step.KeepIfSmaller && len(result) >= len(data) {
+		status = STEP_STATUS_DISCARDED
+	}
+	return
+}
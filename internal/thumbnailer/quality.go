@@ -1,6 +1,11 @@
 package thumbnailer
 
+import "github.com/urvin/gokaru/internal/config"
+
 type quality struct {
 	Quality    uint
 	Iterations uint
+	Effort     uint
+	Encoder    config.Encoder
+	Target     config.Target
 }
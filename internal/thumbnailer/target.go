@@ -0,0 +1,117 @@
+package thumbnailer
+
+import (
+	"fmt"
+	"github.com/urvin/gokaru/internal/metrics"
+	"github.com/urvin/gokaru/internal/vips"
+	"strings"
+)
+
+const (
+	TARGET_MIN_QUALITY_DEFAULT  = 30
+	TARGET_MAX_QUALITY_DEFAULT  = 95
+	TARGET_MAX_ATTEMPTS_DEFAULT = 6
+)
+
+const (
+	TARGET_STATUS_REACHED   = "reached"
+	TARGET_STATUS_UNREACHED = "unreached"
+)
+
+// supportsTarget is true for formats with lossy quality setting
+func supportsTarget(imgtype vips.ImageType) bool {
+	return imgtype == vips.ImageTypeJPEG ||
+		imgtype == vips.ImageTypeWEBP ||
+		imgtype == vips.ImageTypeAVIF ||
+		imgtype == vips.ImageTypeJXL
+}
+
+// searchQuality binary searches the lowest quality within bounds reaching the target similarity, the max quality is
+// used when the target is not reached in the given attempts
+func (t *thumbnailer) searchQuality(imageId uint64, image *vips.Image, imgtype vips.ImageType, q quality) (thumbnail []byte, chosen uint, err error) {
+	minQuality := q.Target.MinQuality
+	if minQuality == 0 {
+		minQuality = TARGET_MIN_QUALITY_DEFAULT
+	}
+	maxQuality := q.Target.MaxQuality
+	if maxQuality == 0 {
+		maxQuality = TARGET_MAX_QUALITY_DEFAULT
+	}
+	minQuality = min(minQuality, maxQuality)
+	maxAttempts := q.Target.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = TARGET_MAX_ATTEMPTS_DEFAULT
+	}
+
+	format := strings.TrimPrefix(imgtype.Mime(), "image/")
+	attempts := uint(0)
+	defer func() {
+		metrics.Add("gokaru_target_quality_attempts_total", float64(attempts), "format", format)
+	}()
+
+	low, high := minQuality, maxQuality
+	for low <= high && attempts < maxAttempts {
+		q.Quality = (low + high) / 2
+		attempts++
+
+		data, er := t.save(image, imgtype, q)
+		if er != nil {
+			err = er
+			return
+		}
+		ssim, er := t.similarity(image, data, imgtype)
+		if er != nil {
+			err = er
+			return
+		}
+
+		t.logger.Debug(
+			fmt.Sprintf("#%d target quality attempt", imageId),
+			"context", "thumbnailer",
+			"quality", q.Quality,
+			"ssim", ssim,
+		)
+
+		if ssim >= q.Target.Ssim {
+			thumbnail, chosen = data, q.Quality
+			high = q.Quality - 1
+		} else {
+			low = q.Quality + 1
+		}
+	}
+
+	status := TARGET_STATUS_REACHED
+	if thumbnail == nil {
+		status = TARGET_STATUS_UNREACHED
+		q.Quality = maxQuality
+		chosen = maxQuality
+		thumbnail, err = t.save(image, imgtype, q)
+		if err != nil {
+			return
+		}
+	}
+
+	t.logger.Info(
+		fmt.Sprintf("#%d target quality", imageId),
+		"context", "thumbnailer",
+		"quality", chosen,
+		"ssim", q.Target.Ssim,
+		"attempts", attempts,
+		"status", status,
+	)
+	metrics.Add("gokaru_target_quality_total", 1, "format", format, "status", status)
+	metrics.Add("gokaru_target_quality_sum", float64(chosen), "format", format)
+
+	return
+}
+
+// similarity decodes an encoded image and compares it with the image
+func (t *thumbnailer) similarity(image *vips.Image, data []byte, imgtype vips.ImageType) (float64, error) {
+	candidate := new(vips.Image)
+	defer candidate.Clear()
+
+	if err := candidate.Load(data, imgtype, 1, 1.0, 1); err != nil {
+		return 0, err
+	}
+	return image.Ssim(candidate)
+}
@@ -20,6 +20,15 @@ type ThumbnailOptions struct {
 	opaqueBackground      bool
 	transparentBackground bool
 	padding               bool
+	trimThreshold         float64
+	trimColor             string
+	paddingSize           uint
+	quality               uint
+	blur                  float32
+	sharpen               float32
+	category              string
+	profile               string
+	metadata              []string
 }
 
 func (to *ThumbnailOptions) Width() uint {
@@ -54,6 +63,45 @@ func (to *ThumbnailOptions) Padding() bool {
 	return to.padding
 }
 
+// TrimThreshold is a colour difference ignored by trim
+func (to *ThumbnailOptions) TrimThreshold() float64 {
+	return to.trimThreshold
+}
+
+// TrimColor is a hex colour of trimmed edges, empty or "auto" for the corner colour
+func (to *ThumbnailOptions) TrimColor() string {
+	return to.trimColor
+}
+
+// PaddingSize is a padding of a trimmed image
+func (to *ThumbnailOptions) PaddingSize() uint {
+	return to.paddingSize
+}
+
+func (to *ThumbnailOptions) Quality() uint {
+	return to.quality
+}
+
+func (to *ThumbnailOptions) Blur() float32 {
+	return to.blur
+}
+
+func (to *ThumbnailOptions) Sharpen() float32 {
+	return to.sharpen
+}
+
+func (to *ThumbnailOptions) Category() string {
+	return to.category
+}
+
+func (to *ThumbnailOptions) Profile() string {
+	return to.profile
+}
+
+func (to *ThumbnailOptions) Metadata() []string {
+	return to.metadata
+}
+
 func (to *ThumbnailOptions) ResizeMethod() RezizeMethod {
 	return to.resizeMethod
 }
@@ -107,3 +155,34 @@ func (to *ThumbnailOptions) hasCast(needle uint, haystack uint) bool {
 func (to *ThumbnailOptions) SetTrim(trim bool) {
 	to.trim = trim
 }
+
+// SetTrimSettings sets trim threshold, colour and padding, an empty colour is the corner one
+func (to *ThumbnailOptions) SetTrimSettings(threshold float64, color string, padding uint) {
+	to.trimThreshold = threshold
+	to.trimColor = color
+	to.paddingSize = padding
+}
+
+// SetQuality overrides configured quality of the format, 0 keeps it
+func (to *ThumbnailOptions) SetQuality(quality uint) {
+	to.quality = quality
+}
+
+// SetCategory selects quality settings configured for the category
+func (to *ThumbnailOptions) SetCategory(category string) {
+	to.category = category
+}
+
+// SetPreserve sets colour profile handling and metadata kept in a thumbnail
+func (to *ThumbnailOptions) SetPreserve(profile string, metadata []string) {
+	to.profile = profile
+	to.metadata = metadata
+}
+
+func (to *ThumbnailOptions) SetBlur(sigma float32) {
+	to.blur = sigma
+}
+
+func (to *ThumbnailOptions) SetSharpen(sigma float32) {
+	to.sharpen = sigma
+}
@@ -4,23 +4,24 @@ import (
 	"errors"
 	"fmt"
 	"github.com/urvin/gokaru/internal/config"
-	"github.com/urvin/gokaru/internal/helper"
+	"github.com/urvin/gokaru/internal/contracts"
+	"github.com/urvin/gokaru/internal/metrics"
 	helper2 "github.com/urvin/gokaru/internal/server/helper"
 	"github.com/urvin/gokaru/internal/vips"
-	"io/ioutil"
 	"log/slog"
 	"math"
-	"os"
 	"runtime"
-	"strconv"
+	"sync"
 )
 
 type thumbnailer struct {
 	logger  *slog.Logger
 	imageId uint64
+	// commands of post-processing steps reported missing
+	missing sync.Map
 }
 
-func (t *thumbnailer) Thumbnail(origin []byte, options ThumbnailOptions) (thumbnail []byte, later func([]byte) ([]byte, error), err error) {
+func (t *thumbnailer) Thumbnail(origin []byte, options ThumbnailOptions) (thumbnail []byte, targetQuality uint, later func([]byte) ([]byte, error), err error) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 	defer vips.Cleanup()
@@ -74,53 +75,69 @@ func (t *thumbnailer) Thumbnail(origin []byte, options ThumbnailOptions) (thumbn
 		return
 	}
 
-	q := t.getQuality(uint(image.Width()), uint(image.Height()), options.ImageType())
-
-	t.logger.Info(
-		fmt.Sprintf("#%d quality check", imageId),
-		"context", "thumbnailer",
-		"quality", q.Quality,
-	)
+	q := t.getQuality(uint(image.Width()), uint(image.Height()), options.ImageType(), options.Category())
+	if options.Quality() > 0 {
+		q.Quality = min(options.Quality(), 100)
+		q.Target = config.Target{}
+	}
 
-	switch options.ImageType() {
-	case vips.ImageTypeJPEG:
-		jo := vips.NewJpegSaveOptions()
-		jo.Quality = int(q.Quality)
-		thumbnail, err = image.SaveJpeg(jo)
-	case vips.ImageTypePNG:
-		po := vips.NewPngSaveOptions()
-		po.Quantize = true
-		if q.Quality == 100 {
-			po.Quantize = false
-		}
-		thumbnail, err = image.SavePng(po)
-		if q.Iterations > 0 {
-			later = t.laterOptimizePng
-		}
-	case vips.ImageTypeWEBP:
-		wo := vips.NewWebpSaveOptions()
-		wo.Quality = int(q.Quality)
-		thumbnail, err = image.SaveWebp(wo)
-	case vips.ImageTypeAVIF:
-		ao := vips.NewAvifSaveptions()
-		ao.Quality = int(q.Quality)
-		thumbnail, err = image.SaveAvif(ao)
-	default:
-		thumbnail, err = image.Save(options.ImageType(), int(q.Quality))
+	if q.Target.Ssim > 0 && supportsTarget(options.ImageType()) && !image.IsAnimated() {
+		thumbnail, targetQuality, err = t.searchQuality(imageId, image, options.ImageType(), q)
+	} else {
+		t.logger.Info(
+			fmt.Sprintf("#%d quality check", imageId),
+			"context", "thumbnailer",
+			"quality", q.Quality,
+		)
+		thumbnail, err = t.save(image, options.ImageType(), q)
+	}
+	if err == nil {
+		later = t.Later(options)
 	}
 
 	return
 }
 
-func (t *thumbnailer) transformFrames(imageId uint64, origin []byte, image *vips.Image, originType vips.ImageType, options *ThumbnailOptions) (err error) {
-	if options.Trim() {
-		options.SetTrim(false)
-		t.logger.Warn(
-			"Trim is not supported for animated images",
-			"context", "thumbnailer",
-		)
+func (t *thumbnailer) Inspect(origin []byte) (info ImageInfo, err error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer vips.Cleanup()
+
+	originMime := helper2.MimeByData(origin)
+	info.ImageType = vips.ImageTypeByByMime(originMime)
+	if info.ImageType == vips.ImageTypeUnknown {
+		err = errors.New("unknown origin image type")
+		return
+	}
+
+	image := new(vips.Image)
+	defer image.Clear()
+
+	err = image.Load(origin, info.ImageType, 1, 1.0, 1)
+	if err != nil {
+		return
+	}
+
+	info.Width = image.Width()
+	info.Height = image.Height()
+	if info.ImageType.SupportsAnimation() {
+		pages, _ := image.GetIntDefault("n-pages", 1)
+		info.Animated = pages > 1
 	}
+	info.Alpha = image.HasAlpha()
+	switch info.ImageType {
+	case vips.ImageTypeGIF:
+		info.Palette = true
+	case vips.ImageTypePNG:
+		// libvips before 8.15 reports palette bit depth only
+		depth, _ := image.GetIntDefault("palette-bit-depth", 0)
+		palette, _ := image.GetIntDefault("palette", 0)
+		info.Palette = depth > 0 || palette > 0
+	}
+	return
+}
 
+func (t *thumbnailer) transformFrames(imageId uint64, origin []byte, image *vips.Image, originType vips.ImageType, options *ThumbnailOptions) (err error) {
 	imgWidth := image.Width()
 
 	frameHeight, err := image.GetInt("page-height")
@@ -166,6 +183,7 @@ func (t *thumbnailer) transformFrames(imageId uint64, origin []byte, image *vips
 		}
 	}()
 
+	flattened := false
 	for i := 0; i < framesCount; i++ {
 		frame := new(vips.Image)
 
@@ -175,7 +193,30 @@ func (t *thumbnailer) transformFrames(imageId uint64, origin []byte, image *vips
 
 		frames[i] = frame
 
-		if err = t.transformFrame(imageId, frame, options); err != nil {
+		if flattened, err = t.prepareFrame(imageId, frame, options); err != nil {
+			return err
+		}
+	}
+
+	// frames are cropped to the union of their contents, so that the animation does not jump
+	if options.Trim() {
+		area := vips.Rect{}
+		for _, frame := range frames {
+			frameArea, er := t.trimArea(imageId, frame, options)
+			if er != nil {
+				return er
+			}
+			area = area.Union(frameArea)
+		}
+		for _, frame := range frames {
+			if err = cropFrame(frame, area); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, frame := range frames {
+		if err = t.resizeFrame(imageId, frame, options, flattened, options.Trim()); err != nil {
 			return err
 		}
 
@@ -218,20 +259,106 @@ func (t *thumbnailer) transformFrames(imageId uint64, origin []byte, image *vips
 	return
 }
 
+// save encodes an image with quality and encoder settings
+func (t *thumbnailer) save(image *vips.Image, imgtype vips.ImageType, q quality) (thumbnail []byte, err error) {
+	switch imgtype {
+	case vips.ImageTypeJPEG:
+		jo := vips.NewJpegSaveOptions()
+		jo.Quality = int(q.Quality)
+		setOption(&jo.Interlace, q.Encoder.Interlace)
+		setOption(&jo.OptimizeCoding, q.Encoder.OptimizeCoding)
+		if mode, ok := SUBSAMPLE_MODES[q.Encoder.Subsample]; ok {
+			jo.SubsampleMode = mode
+		}
+		setOption(&jo.TrellisQuant, q.Encoder.TrellisQuant)
+		setOption(&jo.OvershootDeringing, q.Encoder.OvershootDeringing)
+		setOption(&jo.OptimizeScans, q.Encoder.OptimizeScans)
+		setOption(&jo.QuantTable, q.Encoder.QuantTable)
+		thumbnail, err = image.SaveJpeg(jo)
+	case vips.ImageTypePNG:
+		po := vips.NewPngSaveOptions()
+		po.Quantize = true
+		if q.Quality == 100 {
+			po.Quantize = false
+		}
+		setOption(&po.Interlace, q.Encoder.Interlace)
+		setOption(&po.Compression, q.Encoder.Compression)
+		if q.Encoder.Colors != nil {
+			po.Quantize = true
+			po.Colors = *q.Encoder.Colors
+		}
+		thumbnail, err = image.SavePng(po)
+	case vips.ImageTypeWEBP:
+		wo := vips.NewWebpSaveOptions()
+		wo.Quality = int(q.Quality)
+		setOption(&wo.Lossless, q.Encoder.Lossless)
+		setOption(&wo.SmartSubsample, q.Encoder.SmartSubsample)
+		setOption(&wo.ReductionEffort, q.Encoder.ReductionEffort)
+		thumbnail, err = image.SaveWebp(wo)
+	case vips.ImageTypeAVIF:
+		ao := vips.NewAvifSaveptions()
+		ao.Quality = int(q.Quality)
+		setOption(&ao.Lossless, q.Encoder.Lossless)
+		setOption(&ao.Speed, q.Encoder.Speed)
+		thumbnail, err = image.SaveAvif(ao)
+	case vips.ImageTypeJXL:
+		jo := vips.NewJxlSaveOptions()
+		jo.Quality = int(q.Quality)
+		if q.Effort > 0 {
+			jo.Effort = int(min(q.Effort, JXL_EFFORT_MAX))
+		}
+		setOption(&jo.Lossless, q.Encoder.Lossless)
+		thumbnail, err = image.SaveJxl(jo)
+	default:
+		thumbnail, err = image.Save(imgtype, int(q.Quality))
+	}
+	return
+}
+
+const TRIM_THRESHOLD_DEFAULT = 10
+const JXL_EFFORT_MAX = 9
+
+// SUBSAMPLE_MODES are chroma subsampling modes of jpeg encoder by configured names
+var SUBSAMPLE_MODES = map[string]vips.SubsampleMode{
+	"auto": vips.VipsForeignSubsampleAuto,
+	"on":   vips.VipsForeignSubsampleOn,
+	"off":  vips.VipsForeignSubsampleOff,
+}
+
+var whiteColor = vips.RgbColor{
+	R: 255,
+	G: 255,
+	B: 255,
+}
+
 func (t *thumbnailer) transformFrame(imageId uint64, image *vips.Image, options *ThumbnailOptions) (err error) {
-	whiteColor := vips.RgbColor{
-		R: 255,
-		G: 255,
-		B: 255,
+	flattened, err := t.prepareFrame(imageId, image, options)
+	if err != nil {
+		return
 	}
+
 	trimmed := false
-	flattened := false
+	if options.Trim() {
+		area, er := t.trimArea(imageId, image, options)
+		if er != nil {
+			return er
+		}
+		if err = cropFrame(image, area); err != nil {
+			return
+		}
+		trimmed = true
+	}
+
+	return t.resizeFrame(imageId, image, options, flattened, trimmed)
+}
 
+// prepareFrame converts a frame to sRGB and sets its background, the background is flattened if the result has no alpha
+func (t *thumbnailer) prepareFrame(imageId uint64, image *vips.Image, options *ThumbnailOptions) (flattened bool, err error) {
 	if err = image.Rad2Float(); err != nil {
-		return err
+		return
 	}
 	if err = image.RgbColourspace(); err != nil {
-		return err
+		return
 	}
 
 	// set transparent background
@@ -246,7 +373,7 @@ func (t *thumbnailer) transformFrame(imageId uint64, image *vips.Image, options
 			return
 		}
 		if err = image.CopyMemory(); err != nil {
-			return err
+			return
 		}
 	}
 
@@ -263,33 +390,48 @@ func (t *thumbnailer) transformFrame(imageId uint64, image *vips.Image, options
 			return
 		}
 		if err = image.CopyMemory(); err != nil {
-			return err
+			return
 		}
 	}
 
-	// trim
-	if options.Trim() {
-		t.logger.Info(
-			fmt.Sprintf("#%d smart trim", imageId),
-			"context", "thumbnailer",
-		)
+	return
+}
 
-		err = image.Trim(
-			10,
-			true,
-			whiteColor,
-			false,
-			false,
-		)
+// trimArea finds the content of a frame by the trim colour, the colour of its corner by default
+func (t *thumbnailer) trimArea(imageId uint64, image *vips.Image, options *ThumbnailOptions) (vips.Rect, error) {
+	threshold := options.TrimThreshold()
+
+	smart := true
+	color := whiteColor
+	if options.TrimColor() != "" && options.TrimColor() != contracts.TRIM_COLOR_AUTO {
+		parsed, err := vips.ParseRgbColor(options.TrimColor())
 		if err != nil {
-			return
-		}
-		trimmed = true
-		if err = image.CopyMemory(); err != nil {
-			return err
+			return vips.Rect{}, err
 		}
+		smart = false
+		color = parsed
 	}
 
+	t.logger.Info(
+		fmt.Sprintf("#%d trim with threshold %g, smart: %t", imageId, threshold, smart),
+		"context", "thumbnailer",
+	)
+
+	return image.FindTrim(threshold, smart, color)
+}
+
+// cropFrame crops a frame to an area, a frame is kept as is for an empty area
+func cropFrame(image *vips.Image, area vips.Rect) (err error) {
+	if area.Empty() || area.Width == image.Width() && area.Height == image.Height() {
+		return
+	}
+	if err = image.Crop(area.Left, area.Top, area.Width, area.Height); err != nil {
+		return
+	}
+	return image.CopyMemory()
+}
+
+func (t *thumbnailer) resizeFrame(imageId uint64, image *vips.Image, options *ThumbnailOptions, flattened, trimmed bool) (err error) {
 	// resize
 	forceExtent := false
 	shouldResize := false
@@ -324,7 +466,7 @@ func (t *thumbnailer) transformFrame(imageId uint64, image *vips.Image, options
 		forceExtent = true
 	}
 
-	padding := config.Get().Padding
+	padding := options.PaddingSize()
 	if options.Padding() && options.Trim() && resizeWidth > 2*padding && resizeHeight > 2*padding {
 		t.logger.Info(
 			fmt.Sprintf("#%d add padding", imageId),
@@ -375,16 +517,35 @@ func (t *thumbnailer) transformFrame(imageId uint64, image *vips.Image, options
 		}
 	}
 
-	if err = image.TransformColourProfile(); err != nil {
-		return err
+	if options.Blur() > 0 {
+		t.logger.Info(
+			fmt.Sprintf("#%d blur with sigma %g", imageId, options.Blur()),
+			"context", "thumbnailer",
+		)
+
+		if err = image.Blur(options.Blur()); err != nil {
+			return err
+		}
 	}
-	if err = image.RemoveColourProfile(); err != nil {
+
+	if options.Sharpen() > 0 {
+		t.logger.Info(
+			fmt.Sprintf("#%d sharpen with sigma %g", imageId, options.Sharpen()),
+			"context", "thumbnailer",
+		)
+
+		if err = image.Sharpen(options.Sharpen()); err != nil {
+			return err
+		}
+	}
+
+	if err = convertProfile(image, options.Profile()); err != nil {
 		return err
 	}
 	if err = image.CastUchar(); err != nil {
 		return err
 	}
-	if err = image.Strip(); err != nil {
+	if err = stripMetadata(image, options.Metadata()); err != nil {
 		return err
 	}
 	if err = image.CopyMemory(); err != nil {
@@ -394,35 +555,46 @@ func (t *thumbnailer) transformFrame(imageId uint64, image *vips.Image, options
 	return nil
 }
 
-func (t *thumbnailer) getQuality(width, height uint, imgtype vips.ImageType) quality {
+func (t *thumbnailer) getQuality(width, height uint, imgtype vips.ImageType, category string) quality {
 	defaultQuality := quality{
 		Quality:    config.Get().QualityDefault,
 		Iterations: 100,
 	}
 
-	imgTypeExtension := imgtype.String()
+	// a format could be configured by any of its names, jpg and jpeg for example
+	var formats []string
+	for format, formatType := range vips.ImageTypes {
+		if formatType == imgtype {
+			formats = append(formats, format)
+		}
+	}
 
 	result := defaultQuality
 	halfPerimeter := width + height
 
-	for _, qualityFormat := range config.Get().Quality {
-		if qualityFormat.Format == imgTypeExtension {
-			result.Quality = qualityFormat.Quality
-			result.Iterations = qualityFormat.Iterations
-
-			for _, condition := range qualityFormat.Conditions {
-				if halfPerimeter >= condition.From && halfPerimeter < condition.To {
-					result.Quality = condition.Quality
-					result.Iterations = condition.Iterations
-					break
+	if qualityFormat, ok := config.Get().QualityOf(category, formats...); ok {
+		result.Quality = qualityFormat.Quality
+		result.Iterations = qualityFormat.Iterations
+		result.Effort = qualityFormat.Effort
+		result.Encoder = qualityFormat.Encoder
+		result.Target = qualityFormat.Target
+
+		for _, condition := range qualityFormat.Conditions {
+			if halfPerimeter >= condition.From && halfPerimeter < condition.To {
+				result.Quality = condition.Quality
+				result.Iterations = condition.Iterations
+				if condition.Effort > 0 {
+					result.Effort = condition.Effort
 				}
+				result.Encoder = result.Encoder.Merge(condition.Encoder)
+				break
 			}
-			break
 		}
 	}
 
 	if result.Quality <= 0 {
-		result = defaultQuality
+		result.Quality = defaultQuality.Quality
+		result.Iterations = defaultQuality.Iterations
 	}
 	if result.Quality > 100 {
 		result.Quality = 100
@@ -434,60 +606,47 @@ func (t *thumbnailer) getQuality(width, height uint, imgtype vips.ImageType) qua
 	return result
 }
 
-func (t *thumbnailer) laterOptimizePng(uncompressed []byte) (compressed []byte, err error) {
-	originMime := helper2.MimeByData(uncompressed)
-	originType := vips.ImageTypeByByMime(originMime)
-	if originType == vips.ImageTypeUnknown {
-		err = errors.New("unknown origin image type")
-		return
-	}
-
-	image := new(vips.Image)
-	defer image.Clear()
-	defer vips.Cleanup()
-
-	err = image.Load(uncompressed, originType, 1, 1.0, 1)
-	if err != nil {
-		return
-	}
-
-	quality := t.getQuality(uint(image.Width()), uint(image.Height()), originType)
-
-	uncompressedFile, err := ioutil.TempFile("", "thumbnail-pngi")
-	defer func(name string) {
-		_ = os.Remove(name)
-	}(uncompressedFile.Name())
-
-	err = ioutil.WriteFile(uncompressedFile.Name(), uncompressed, 0644)
-	if err != nil {
-		return
+// setOption replaces an encoder default with a configured value
+func setOption[T any](option *T, value *T) {
+	if value != nil {
+		*option = *value
 	}
+}
 
-	compressedFile, err := ioutil.TempFile("", "thumbnail-pngo")
-	defer func(name string) {
-		_ = os.Remove(name)
-	}(compressedFile.Name())
+func (t *thumbnailer) newImageId() uint64 {
+	t.imageId++
+	return t.imageId
+}
 
-	_, err = helper.Exec("zopflipng", "--iterations="+strconv.FormatUint(uint64(quality.Iterations), 10), "-y", "--filters=01234mepb", "--lossy_8bit", "--lossy_transparent", uncompressedFile.Name(), compressedFile.Name())
-	if err != nil {
-		return
-	}
+// SupportsInput is false for origin formats libvips was built without
+func SupportsInput(extension string) bool {
+	return vips.ImageTypeByExtension(extension).SupportsLoad()
+}
 
-	compressed, err = ioutil.ReadFile(compressedFile.Name())
-	if err != nil {
-		return
-	}
+// SupportsOutput is false for thumbnail formats libvips was built without
+func SupportsOutput(extension string) bool {
+	return vips.ImageTypeByExtension(extension).SupportsSave()
+}
 
-	return
+// SupportsAnimation is true for thumbnail formats keeping frames of animated origins
+func SupportsAnimation(extension string) bool {
+	return vips.ImageTypeByExtension(extension).SupportsAnimation()
 }
 
-func (t *thumbnailer) newImageId() uint64 {
-	t.imageId++
-	return t.imageId
+// SupportsAlpha is true for thumbnail formats keeping transparency
+func SupportsAlpha(extension string) bool {
+	return vips.ImageTypeByExtension(extension).SupportsAlpha()
 }
 
 func NewThumbnailer(logger *slog.Logger) Thumbnailer {
 	result := &thumbnailer{}
 	result.logger = logger
+
+	metrics.Register("gokaru_postprocess_step_total", metrics.TYPE_COUNTER, "Post-processing steps by format, step and status")
+	metrics.Register("gokaru_postprocess_step_seconds_total", metrics.TYPE_COUNTER, "Time spent in post-processing steps by format and step")
+	metrics.Register("gokaru_postprocess_step_saved_bytes_total", metrics.TYPE_COUNTER, "Bytes saved by post-processing steps by format and step")
+	metrics.Register("gokaru_target_quality_total", metrics.TYPE_COUNTER, "Target similarity searches by format and status")
+	metrics.Register("gokaru_target_quality_sum", metrics.TYPE_COUNTER, "Sum of qualities chosen by target similarity searches by format")
+	metrics.Register("gokaru_target_quality_attempts_total", metrics.TYPE_COUNTER, "Encodings made by target similarity searches by format")
 	return result
 }
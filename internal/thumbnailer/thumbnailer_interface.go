@@ -1,5 +1,9 @@
 package thumbnailer
 
 type Thumbnailer interface {
-	Thumbnail(origin []byte, options ThumbnailOptions) (thumbnail []byte, later func([]byte) ([]byte, error), err error)
+	// Thumbnail returns targetQuality chosen by a target similarity search, 0 for fixed quality
+	Thumbnail(origin []byte, options ThumbnailOptions) (thumbnail []byte, targetQuality uint, later func([]byte) ([]byte, error), err error)
+	Inspect(origin []byte) (info ImageInfo, err error)
+	// Later returns post-processing of thumbnails of the options, nil if there is none
+	Later(options ThumbnailOptions) func([]byte) ([]byte, error)
 }
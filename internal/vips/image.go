@@ -44,6 +44,8 @@ func (img *Image) Load(data []byte, imgtype ImageType, shrink int, scale float64
 		err = C.vips_bmpload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), &tmp)
 	case ImageTypeTIFF:
 		err = C.vips_tiffload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), &tmp)
+	case ImageTypeJXL:
+		err = C.vips_jxlload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), &tmp)
 	}
 	if err != 0 {
 		return vipsError()
@@ -134,6 +136,24 @@ func (img *Image) SaveAvif(options AvifSaveOptions) ([]byte, error) {
 	return b, nil
 }
 
+func (img *Image) SaveJxl(options JxlSaveOptions) ([]byte, error) {
+	var ptr unsafe.Pointer
+	err := C.int(0)
+	imgsize := C.size_t(0)
+
+	err = C.vips_jxlsave_go(img.VipsImage, &ptr, &imgsize,
+		C.int(options.Quality),
+		C.int(options.Effort),
+		gbool(options.Lossless))
+
+	if err != 0 {
+		C.g_free_go(&ptr)
+		return nil, vipsError()
+	}
+	b := ptrToBytes(ptr, int(imgsize))
+	return b, nil
+}
+
 func (img *Image) Save(imgtype ImageType, quality int) ([]byte, error) {
 	var ptr unsafe.Pointer
 
@@ -155,6 +175,8 @@ func (img *Image) Save(imgtype ImageType, quality int) ([]byte, error) {
 		err = C.vips_bmpsave_go(img.VipsImage, &ptr, &imgsize)
 	case ImageTypeTIFF:
 		err = C.vips_tiffsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality))
+	case ImageTypeJXL:
+		err = C.vips_jxlsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality), C.int(7), gbool(false))
 	}
 	if err != 0 {
 		C.g_free_go(&ptr)
@@ -255,6 +277,24 @@ func (img *Image) SetIntSlice(name string, value []int) {
 	C.vips_image_set_array_int_go(img.VipsImage, cachedCString(name), &in[0], C.int(len(value)))
 }
 
+// GetBlob returns a copy of a blob field, nil if there is none
+func (img *Image) GetBlob(name string) []byte {
+	var ptr unsafe.Pointer
+	size := C.size_t(0)
+
+	if C.vips_image_get_blob_go(img.VipsImage, cachedCString(name), &ptr, &size) != 0 || size == 0 {
+		return nil
+	}
+	return C.GoBytes(ptr, C.int(size))
+}
+
+func (img *Image) SetBlob(name string, value []byte) {
+	if len(value) == 0 {
+		return
+	}
+	C.vips_image_set_blob_go(img.VipsImage, cachedCString(name), unsafe.Pointer(&value[0]), C.size_t(len(value)))
+}
+
 func (img *Image) CastUchar() error {
 	var tmp *C.VipsImage
 
@@ -375,6 +415,23 @@ func (img *Image) Trim(threshold float64, smart bool, color RgbColor, equalHor b
 	return nil
 }
 
+// FindTrim returns the area of an image content, empty for an image of the background only
+func (img *Image) FindTrim(threshold float64, smart bool, color RgbColor) (Rect, error) {
+	var left, top, width, height C.int
+
+	if err := img.CopyMemory(); err != nil {
+		return Rect{}, err
+	}
+
+	if C.vips_find_trim_go(img.VipsImage, C.double(threshold),
+		gbool(smart), C.double(color.R), C.double(color.G), C.double(color.B),
+		&left, &top, &width, &height) != 0 {
+		return Rect{}, vipsError()
+	}
+
+	return Rect{Left: int(left), Top: int(top), Width: int(width), Height: int(height)}, nil
+}
+
 func (img *Image) EnsureAlpha() error {
 	var tmp *C.VipsImage
 
@@ -386,6 +443,34 @@ func (img *Image) EnsureAlpha() error {
 	return nil
 }
 
+// TransparentBackground makes pixels of the corner colour connected to image edges transparent, fuzz is a relative
+// colour distance, feather is a blur sigma of the edge
+func (img *Image) TransparentBackground(fuzz, feather float64) error {
+	var tmp *C.VipsImage
+
+	if err := img.CopyMemory(); err != nil {
+		return err
+	}
+
+	if C.vips_transparent_background_go(img.VipsImage, &tmp, C.double(fuzz), C.double(feather)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// Ssim compares the image with a candidate of the same size, 1 for identical images
+func (img *Image) Ssim(candidate *Image) (float64, error) {
+	var ssim C.double
+
+	if C.vips_ssim_go(img.VipsImage, candidate.VipsImage, &ssim) != 0 {
+		return 0, vipsError()
+	}
+
+	return float64(ssim), nil
+}
+
 func (img *Image) Flatten(bg RgbColor) error {
 	var tmp *C.VipsImage
 
@@ -494,6 +579,29 @@ func (img *Image) TransformColourProfile() error {
 	return nil
 }
 
+// HasWideColourProfile is true for embedded RGB profiles other than sRGB
+func (img *Image) HasWideColourProfile() bool {
+	return C.vips_has_embedded_icc(img.VipsImage) != 0 &&
+		C.vips_icc_is_rgb(img.VipsImage) != 0 &&
+		C.vips_icc_is_srgb_iec61966(img.VipsImage) == 0
+}
+
+// TransformColourProfileToP3 converts an image with a wide colour profile to Display P3 embedding the P3 profile
+func (img *Image) TransformColourProfileToP3() error {
+	var tmp *C.VipsImage
+
+	if !img.HasWideColourProfile() {
+		return nil
+	}
+
+	if C.vips_icc_transform_p3_go(img.VipsImage, &tmp) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
 func (img *Image) RemoveColourProfile() error {
 	var tmp *C.VipsImage
 
@@ -600,6 +708,26 @@ func (img *Image) Strip() error {
 	return nil
 }
 
+// StripExcept removes metadata like Strip, keeping the named fields
+func (img *Image) StripExcept(keep ...string) error {
+	if len(keep) == 0 {
+		return img.Strip()
+	}
+
+	var tmp *C.VipsImage
+
+	names := make([]*C.char, len(keep))
+	for i, name := range keep {
+		names[i] = cachedCString(name)
+	}
+	if C.vips_strip_except_go(img.VipsImage, &tmp, &names[0], C.int(len(names))) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
 func (img *Image) Thumbnail(width, height int) error {
 	var tmp *C.VipsImage
 
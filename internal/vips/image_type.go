@@ -19,6 +19,7 @@ const (
 	ImageTypeAVIF    = ImageType(C.AVIF)
 	ImageTypeBMP     = ImageType(C.BMP)
 	ImageTypeTIFF    = ImageType(C.TIFF)
+	ImageTypeJXL     = ImageType(C.JXL)
 )
 
 var (
@@ -34,6 +35,7 @@ var (
 		"avif": ImageTypeAVIF,
 		"bmp":  ImageTypeBMP,
 		"tiff": ImageTypeTIFF,
+		"jxl":  ImageTypeJXL,
 	}
 
 	mimes = map[ImageType]string{
@@ -47,6 +49,7 @@ var (
 		ImageTypeAVIF: "image/avif",
 		ImageTypeBMP:  "image/bmp",
 		ImageTypeTIFF: "image/tiff",
+		ImageTypeJXL:  "image/jxl",
 	}
 )
 
@@ -75,7 +78,18 @@ func (it ImageType) SupportsColourProfile() bool {
 	return it == ImageTypeJPEG ||
 		it == ImageTypePNG ||
 		it == ImageTypeWEBP ||
-		it == ImageTypeAVIF
+		it == ImageTypeAVIF ||
+		it == ImageTypeJXL
+}
+
+// SupportsLoad is false for formats libvips was built without, known after Startup
+func (it ImageType) SupportsLoad() bool {
+	return vipsTypeSupportLoad[it]
+}
+
+// SupportsSave is false for formats libvips was built without, known after Startup
+func (it ImageType) SupportsSave() bool {
+	return vipsTypeSupportSave[it]
 }
 
 func (it ImageType) SupportsAnimation() bool {
@@ -31,6 +31,12 @@ type AvifSaveOptions struct {
 	Speed    int
 }
 
+type JxlSaveOptions struct {
+	Quality  int
+	Effort   int
+	Lossless bool
+}
+
 // MozJpeg default save options
 func NewJpegSaveOptions() (options JpegSaveOptions) {
 	options.Quality = 75
@@ -66,3 +72,10 @@ func NewAvifSaveptions() (options AvifSaveOptions) {
 	options.Speed = 5
 	return
 }
+
+func NewJxlSaveOptions() (options JxlSaveOptions) {
+	options.Quality = 75
+	options.Effort = 7
+	options.Lossless = false
+	return
+}
@@ -1,4 +1,47 @@
 package vips
 
+import (
+	"encoding/hex"
+	"errors"
+)
+
 type RgbColor struct{ R, G, B uint8 }
+
+// ParseRgbColor reads a rrggbb hex colour
+func ParseRgbColor(color string) (RgbColor, error) {
+	bytes, err := hex.DecodeString(color)
+	if err != nil {
+		return RgbColor{}, err
+	}
+	if len(bytes) != 3 {
+		return RgbColor{}, errors.New("invalid colour " + color)
+	}
+	return RgbColor{R: bytes[0], G: bytes[1], B: bytes[2]}, nil
+}
+
 type RgbAColor struct{ R, G, B, A uint8 }
+
+type Rect struct{ Left, Top, Width, Height int }
+
+// Empty is true for an area without pixels
+func (r Rect) Empty() bool {
+	return r.Width <= 0 || r.Height <= 0
+}
+
+// Union returns the smallest area containing both areas, empty ones are ignored
+func (r Rect) Union(other Rect) Rect {
+	if r.Empty() {
+		return other
+	}
+	if other.Empty() {
+		return r
+	}
+	left := min(r.Left, other.Left)
+	top := min(r.Top, other.Top)
+	return Rect{
+		Left:   left,
+		Top:    top,
+		Width:  max(r.Left+r.Width, other.Left+other.Width) - left,
+		Height: max(r.Top+r.Height, other.Top+other.Height) - top,
+	}
+}
@@ -31,6 +31,9 @@
 #define VIPS_SUPPORT_ARRAY_HEADERS \
   (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 9))
 
+#define VIPS_SUPPORT_P3_PROFILE \
+  (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 14))
+
 #define VIPS_SUPPORT_HEIF \
   (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 8))
 
@@ -51,6 +54,9 @@
 #define VIPS_SUPPORT_PNG_BITDEPTH \
   (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 10))
 
+#define VIPS_SUPPORT_JXL \
+  (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 11))
+
 #define EXIF_ORIENTATION "exif-ifd0-Orientation"
 
 #if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 8))
@@ -106,6 +112,8 @@ vips_type_find_load_go(int imgtype) {
     return vips_type_find("VipsOperation", "magickload_buffer");
   case (TIFF):
     return vips_type_find("VipsOperation", "tiffload_buffer");
+  case (JXL):
+    return vips_type_find("VipsOperation", "jxlload_buffer");
   }
   return 0;
 }
@@ -132,6 +140,8 @@ vips_type_find_save_go(int imgtype) {
     return vips_type_find("VipsOperation", "magicksave_buffer");
   case (TIFF):
     return vips_type_find("VipsOperation", "tiffsave_buffer");
+  case (JXL):
+    return vips_type_find("VipsOperation", "jxlsave_buffer");
   }
 
   return 0;
@@ -231,6 +241,16 @@ vips_tiffload_go(void *buf, size_t len, VipsImage **out) {
 #endif
 }
 
+int
+vips_jxlload_go(void *buf, size_t len, VipsImage **out) {
+#if VIPS_SUPPORT_JXL
+  return vips_jxlload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, NULL);
+#else
+  vips_error("vips_jxlload_go", "Loading JPEG XL is not supported (libvips 8.11+ reuired)");
+  return 1;
+#endif
+}
+
 int
 vips_get_orientation(VipsImage *image) {
 #ifdef VIPS_META_ORIENTATION
@@ -284,6 +304,21 @@ vips_image_get_array_int_go(VipsImage *image, const char *name, int **out, int *
 #endif
 }
 
+int
+vips_image_get_blob_go(VipsImage *image, const char *name, void **data, size_t *len) {
+  if (vips_image_get_typeof(image, name) != VIPS_TYPE_BLOB)
+    return 1;
+
+  return vips_image_get_blob(image, name, (VIPS_BLOB_DATA_TYPE *) data, len);
+}
+
+void
+vips_image_set_blob_go(VipsImage *image, const char *name, const void *data, size_t len) {
+  void *copy = g_malloc(len);
+  memcpy(copy, data, len);
+  vips_image_set_blob(image, name, (VipsCallbackFn) g_free, copy, len);
+}
+
 void
 vips_image_set_array_int_go(VipsImage *image, const char *name, const int *array, int n) {
 #if VIPS_SUPPORT_ARRAY_HEADERS
@@ -397,6 +432,22 @@ vips_has_embedded_icc(VipsImage *in) {
   return vips_image_get_typeof(in, VIPS_META_ICC_NAME) != 0;
 }
 
+int
+vips_icc_is_rgb(VipsImage *in) {
+  VIPS_BLOB_DATA_TYPE data;
+  size_t data_len;
+
+  if (vips_image_get_blob(in, VIPS_META_ICC_NAME, &data, &data_len))
+    return FALSE;
+
+  // Less than header size
+  if (data_len < 128)
+    return FALSE;
+
+  // Data colour space of the profile header
+  return memcmp(data + 16, "RGB ", 4) == 0;
+}
+
 int
 vips_icc_import_go(VipsImage *in, VipsImage **out) {
   return vips_icc_import(in, out, "embedded", TRUE, "pcs", VIPS_PCS_XYZ, NULL);
@@ -417,6 +468,16 @@ vips_icc_transform_go(VipsImage *in, VipsImage **out) {
   return vips_icc_transform(in, out, "sRGB", "embedded", TRUE, "pcs", VIPS_PCS_XYZ, NULL);
 }
 
+int
+vips_icc_transform_p3_go(VipsImage *in, VipsImage **out) {
+#if VIPS_SUPPORT_P3_PROFILE
+  return vips_icc_transform(in, out, "p3", "embedded", TRUE, "pcs", VIPS_PCS_XYZ, NULL);
+#else
+  vips_error("vips_icc_transform_p3_go", "Display P3 profile is not supported (libvips 8.14+ required)");
+  return 1;
+#endif
+}
+
 int
 vips_icc_remove(VipsImage *in, VipsImage **out) {
   if (vips_copy(in, out, NULL)) return 1;
@@ -488,9 +549,9 @@ vips_extract_area_go(VipsImage *in, VipsImage **out, int left, int top, int widt
 }
 
 int
-vips_trim(VipsImage *in, VipsImage **out, double threshold,
-          gboolean smart, double r, double g, double b,
-          gboolean equal_hor, gboolean equal_ver) {
+vips_find_trim_go(VipsImage *in, double threshold,
+                  gboolean smart, double r, double g, double b,
+                  int *left, int *top, int *width, int *height) {
 #if VIPS_SUPPORT_FIND_TRIM
   VipsImage *tmp;
 
@@ -517,14 +578,27 @@ vips_trim(VipsImage *in, VipsImage **out, double threshold,
     bg = 0;
   }
 
+  int res = vips_find_trim(tmp, left, top, width, height, "background", bga, "threshold", threshold, NULL);
+
+  clear_image(&tmp);
+  vips_area_unref((VipsArea *)bga);
+  g_free(bg);
+
+  return res;
+#else
+  vips_error("vips_find_trim_go", "Trim is not supported (libvips 8.6+ reuired)");
+  return 1;
+#endif
+}
+
+int
+vips_trim(VipsImage *in, VipsImage **out, double threshold,
+          gboolean smart, double r, double g, double b,
+          gboolean equal_hor, gboolean equal_ver) {
   int left, right, top, bot, width, height, diff;
 
-  if (vips_find_trim(tmp, &left, &top, &width, &height, "background", bga, "threshold", threshold, NULL)) {
-    clear_image(&tmp);
-    vips_area_unref((VipsArea *)bga);
-    g_free(bg);
+  if (vips_find_trim_go(in, threshold, smart, r, g, b, &left, &top, &width, &height))
     return 1;
-  }
 
   if (equal_hor) {
     right = in->Xsize - left - width;
@@ -548,19 +622,170 @@ vips_trim(VipsImage *in, VipsImage **out, double threshold,
     }
   }
 
-  clear_image(&tmp);
-  vips_area_unref((VipsArea *)bga);
-  g_free(bg);
-
   if (width == 0 || height == 0) {
     return vips_copy(in, out, NULL);
   }
 
   return vips_extract_area(in, out, left, top, width, height, NULL);
-#else
-  vips_error("vips_trim", "Trim is not supported (libvips 8.6+ reuired)");
-  return 1;
-#endif
+}
+
+int
+vips_transparent_background_go(VipsImage *in, VipsImage **out, double fuzz, double feather) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 17);
+
+  gboolean has_alpha = vips_image_hasalpha_go(in);
+  int bands = has_alpha ? in->Bands - 1 : in->Bands;
+  double max = is_16bit(in->Type) ? 65535.0 : 255.0;
+
+  double *bg;
+  int bgn;
+
+  if (
+    vips_extract_band(in, &t[0], 0, "n", bands, NULL) ||
+    vips_cast(t[0], &t[1], VIPS_FORMAT_FLOAT, NULL) ||
+    vips_getpoint(t[1], &bg, &bgn, 0, 0, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  double *ones = g_new(double, bgn);
+  double *shift = g_new(double, bgn);
+  for (int i = 0; i < bgn; i++) {
+    ones[i] = 1.0;
+    shift[i] = -bg[i];
+  }
+
+  // a frame of the corner colour lets the fill go around the image from a single point
+  VipsArrayDouble *bga = vips_array_double_new(bg, bgn);
+  int res = vips_embed(t[1], &t[2], 1, 1, in->Xsize + 2, in->Ysize + 2,
+    "extend", VIPS_EXTEND_BACKGROUND,
+    "background", bga,
+    NULL);
+  vips_area_unref((VipsArea *)bga);
+
+  // colour distance to the corner colour, 0 to 1
+  res = res ||
+    vips_linear(t[2], &t[3], ones, shift, bgn, NULL) ||
+    vips_multiply(t[3], t[3], &t[4], NULL) ||
+    vips_bandmean(t[4], &t[5], NULL) ||
+    vips_math2_const1(t[5], &t[6], VIPS_OPERATION_MATH2_POW, 0.5, NULL) ||
+    vips_relational_const1(t[6], &t[7], VIPS_OPERATION_RELATIONAL_LESSEQ, fuzz * max, NULL);
+
+  g_free(ones);
+  g_free(shift);
+  g_free(bg);
+
+  if (res || !(t[8] = vips_image_copy_memory(t[7]))) {
+    clear_image(&base);
+    return 1;
+  }
+
+  // mark similar pixels connected to edges, erode and feather the rest to keep antialiased edges
+  VipsImage *mask = vips_image_new_matrixv(3, 3,
+    255.0, 255.0, 255.0,
+    255.0, 255.0, 255.0,
+    255.0, 255.0, 255.0);
+
+  res = vips_draw_flood1(t[8], 128, 0, 0, "equal", TRUE, NULL) ||
+    vips_relational_const1(t[8], &t[9], VIPS_OPERATION_RELATIONAL_NOTEQ, 128, NULL) ||
+    vips_morph(t[9], &t[10], mask, VIPS_OPERATION_MORPHOLOGY_ERODE, NULL) ||
+    vips_extract_area(t[10], &t[11], 1, 1, in->Xsize, in->Ysize, NULL);
+  clear_image(&mask);
+
+  if (!res && feather > 0) {
+    res = vips_gaussblur(t[11], &t[12], feather, NULL);
+  } else if (!res) {
+    res = vips_copy(t[11], &t[12], NULL);
+  }
+
+  if (res) {
+    clear_image(&base);
+    return 1;
+  }
+
+  // keep transparency of the origin
+  if (has_alpha) {
+    res = vips_extract_band(in, &t[13], bands, "n", 1, NULL) ||
+      vips_multiply(t[13], t[12], &t[14], NULL) ||
+      vips_linear1(t[14], &t[15], 1.0 / 255.0, 0, NULL);
+  } else {
+    res = vips_linear1(t[12], &t[15], max / 255.0, 0, NULL);
+  }
+
+  res = res ||
+    vips_cast(t[15], &t[16], vips_image_get_format(in), NULL) ||
+    vips_bandjoin2(t[0], t[16], out, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+// mean structural similarity of luminance of images flattened on white, gaussian window of 1.5 sigma
+int
+vips_ssim_go(VipsImage *reference, VipsImage *candidate, double *ssim) {
+  if (reference->Xsize != candidate->Xsize || reference->Ysize != candidate->Ysize) {
+    vips_error("vips_ssim_go", "images differ in size");
+    return 1;
+  }
+
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 29);
+
+  double c1 = (0.01 * 255.0) * (0.01 * 255.0);
+  double c2 = (0.03 * 255.0) * (0.03 * 255.0);
+
+  VipsImage *in[2] = {reference, candidate};
+  for (int i = 0; i < 2; i++) {
+    VipsImage *x = in[i];
+    if (vips_image_hasalpha_go(x)) {
+      if (vips_flatten_go(x, &t[i], 255.0, 255.0, 255.0)) {
+        clear_image(&base);
+        return 1;
+      }
+      x = t[i];
+    }
+    if (
+      vips_colourspace(x, &t[2 + i], VIPS_INTERPRETATION_B_W, NULL) ||
+      vips_cast(t[2 + i], &t[4 + i], VIPS_FORMAT_FLOAT, NULL)
+    ) {
+      clear_image(&base);
+      return 1;
+    }
+  }
+
+  int res = vips_gaussblur(t[4], &t[6], 1.5, NULL) ||
+    vips_gaussblur(t[5], &t[7], 1.5, NULL) ||
+    vips_multiply(t[4], t[4], &t[8], NULL) ||
+    vips_multiply(t[5], t[5], &t[9], NULL) ||
+    vips_multiply(t[4], t[5], &t[10], NULL) ||
+    vips_gaussblur(t[8], &t[11], 1.5, NULL) ||
+    vips_gaussblur(t[9], &t[12], 1.5, NULL) ||
+    vips_gaussblur(t[10], &t[13], 1.5, NULL) ||
+    vips_multiply(t[6], t[6], &t[14], NULL) ||
+    vips_multiply(t[7], t[7], &t[15], NULL) ||
+    vips_multiply(t[6], t[7], &t[16], NULL) ||
+    // variances and covariance
+    vips_subtract(t[11], t[14], &t[17], NULL) ||
+    vips_subtract(t[12], t[15], &t[18], NULL) ||
+    vips_subtract(t[13], t[16], &t[19], NULL) ||
+    // (2 mu1 mu2 + c1) (2 sigma12 + c2) / (mu1^2 + mu2^2 + c1) (sigma1^2 + sigma2^2 + c2)
+    vips_linear1(t[16], &t[20], 2.0, c1, NULL) ||
+    vips_linear1(t[19], &t[21], 2.0, c2, NULL) ||
+    vips_multiply(t[20], t[21], &t[22], NULL) ||
+    vips_add(t[14], t[15], &t[23], NULL) ||
+    vips_linear1(t[23], &t[24], 1.0, c1, NULL) ||
+    vips_add(t[17], t[18], &t[25], NULL) ||
+    vips_linear1(t[25], &t[26], 1.0, c2, NULL) ||
+    vips_multiply(t[24], t[26], &t[27], NULL) ||
+    vips_divide(t[22], t[27], &t[28], NULL) ||
+    vips_avg(t[28], ssim, NULL);
+
+  clear_image(&base);
+
+  return res;
 }
 
 int
@@ -645,6 +870,11 @@ vips_arrayjoin_go(VipsImage **in, VipsImage **out, int n) {
 
 int
 vips_strip(VipsImage *in, VipsImage **out) {
+  return vips_strip_except_go(in, out, NULL, 0);
+}
+
+int
+vips_strip_except_go(VipsImage *in, VipsImage **out, char **keep, int n) {
   static double default_resolution = 72.0 / 25.4;
 
   if (vips_copy(
@@ -661,6 +891,11 @@ vips_strip(VipsImage *in, VipsImage **out) {
 
     if (strcmp(name, VIPS_META_ICC_NAME) == 0) continue;
 
+    gboolean kept = FALSE;
+    for (int j = 0; j < n && !kept; j++)
+      kept = strcmp(name, keep[j]) == 0;
+    if (kept) continue;
+
     vips_image_remove(*out, name);
   }
 
@@ -791,6 +1026,21 @@ vips_avifsave_go(VipsImage *in, void **buf, size_t *len, int quality, int speed,
 #endif
 }
 
+int
+vips_jxlsave_go(VipsImage *in, void **buf, size_t *len, int quality, int effort, gboolean lossless) {
+#if VIPS_SUPPORT_JXL
+  return vips_jxlsave_buffer(
+    in, buf, len,
+    "Q", quality,
+    "effort", effort,
+    "lossless", lossless,
+    NULL);
+#else
+  vips_error("vips_jxlsave_go", "Saving JPEG XL is not supported (libvips 8.11+ reuired)");
+  return 1;
+#endif
+}
+
 int
 vips_bmpsave_go(VipsImage *in, void **buf, size_t *len) {
 #if VIPS_SUPPORT_MAGICK
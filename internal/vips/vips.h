@@ -15,7 +15,8 @@ enum VipsImageTypes {
   HEIC,
   AVIF,
   BMP,
-  TIFF
+  TIFF,
+  JXL
 };
 
 int vips_initialize();
@@ -36,6 +37,7 @@ int vips_svgload_go(void *buf, size_t len, double scale, VipsImage **out);
 int vips_heifload_go(void *buf, size_t len, VipsImage **out);
 int vips_bmpload_go(void *buf, size_t len, VipsImage **out);
 int vips_tiffload_go(void *buf, size_t len, VipsImage **out);
+int vips_jxlload_go(void *buf, size_t len, VipsImage **out);
 
 int vips_get_orientation(VipsImage *image);
 void vips_strip_meta(VipsImage *image);
@@ -49,6 +51,8 @@ gboolean vips_is_animated(VipsImage * in);
 
 int vips_image_get_array_int_go(VipsImage *image, const char *name, int **out, int *n);
 void vips_image_set_array_int_go(VipsImage *image, const char *name, const int *array, int n);
+int vips_image_get_blob_go(VipsImage *image, const char *name, void **data, size_t *len);
+void vips_image_set_blob_go(VipsImage *image, const char *name, const void *data, size_t len);
 
 gboolean vips_image_hasalpha_go(VipsImage * in);
 int vips_addalpha_go(VipsImage *in, VipsImage **out);
@@ -63,10 +67,12 @@ int vips_resize_with_premultiply(VipsImage *in, VipsImage **out, double scale);
 
 int vips_icc_is_srgb_iec61966(VipsImage *in);
 int vips_has_embedded_icc(VipsImage *in);
+int vips_icc_is_rgb(VipsImage *in);
 int vips_icc_import_go(VipsImage *in, VipsImage **out);
 int vips_icc_export_go(VipsImage *in, VipsImage **out);
 int vips_icc_export_srgb(VipsImage *in, VipsImage **out);
 int vips_icc_transform_go(VipsImage *in, VipsImage **out);
+int vips_icc_transform_p3_go(VipsImage *in, VipsImage **out);
 int vips_icc_remove(VipsImage *in, VipsImage **out);
 int vips_colourspace_go(VipsImage *in, VipsImage **out, VipsInterpretation cs);
 
@@ -75,6 +81,9 @@ int vips_flip_horizontal_go(VipsImage *in, VipsImage **out);
 
 int vips_extract_area_go(VipsImage *in, VipsImage **out, int left, int top, int width, int height);
 int vips_smartcrop_go(VipsImage *in, VipsImage **out, int width, int height);
+int vips_find_trim_go(VipsImage *in, double threshold,
+                      gboolean smart, double r, double g, double b,
+                      int *left, int *top, int *width, int *height);
 int vips_trim(VipsImage *in, VipsImage **out, double threshold,
               gboolean smart, double r, double g, double b,
               gboolean equal_hor, gboolean equal_ver);
@@ -88,12 +97,15 @@ int vips_replicate_go(VipsImage *in, VipsImage **out, int across, int down);
 int vips_embed_go(VipsImage *in, VipsImage **out, int x, int y, int width, int height, double *bg, int bgn);
 
 int vips_ensure_alpha(VipsImage *in, VipsImage **out);
+int vips_transparent_background_go(VipsImage *in, VipsImage **out, double fuzz, double feather);
+int vips_ssim_go(VipsImage *reference, VipsImage *candidate, double *ssim);
 
 int vips_apply_watermark(VipsImage *in, VipsImage *watermark, VipsImage **out, double opacity);
 
 int vips_arrayjoin_go(VipsImage **in, VipsImage **out, int n);
 
 int vips_strip(VipsImage *in, VipsImage **out);
+int vips_strip_except_go(VipsImage *in, VipsImage **out, char **keep, int n);
 
 int vips_jpegsave_go(VipsImage *in, void **buf, size_t *len, int quality, int interlace);
 int vips_savejpeg_ext_go(VipsImage *in, void **buf, size_t *len, int quality, gboolean interlace, gboolean optimizeCoding,int subsampleMode, gboolean trellisQuant, gboolean overshootDeringing, gboolean optimizeScans, int quantTable);
@@ -103,6 +115,7 @@ int vips_gifsave_go(VipsImage *in, void **buf, size_t *len);
 int vips_avifsave_go(VipsImage *in, void **buf, size_t *len, int quality, int speed, gboolean lossless);
 int vips_bmpsave_go(VipsImage *in, void **buf, size_t *len);
 int vips_tiffsave_go(VipsImage *in, void **buf, size_t *len, int quality);
+int vips_jxlsave_go(VipsImage *in, void **buf, size_t *len, int quality, int effort, gboolean lossless);
 
 void vips_cleanup();
 